@@ -0,0 +1,306 @@
+// Package auditlog 消息路由与监测客户端共用的审计日志：只追加，每行一条json，
+// 每条记录带上一条的哈希，以HMAC-SHA256形成哈希链，没有密钥无法伪造或重算
+package auditlog
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry 审计记录，hash = HMAC-SHA256(key, prev + 记录本身(hash为空)的json)，前后相连形成哈希链
+type Entry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	ClientId string    `json:"clientId"`
+	Event    string    `json:"event"`
+	Actor    string    `json:"actor"`
+	Target   string    `json:"target,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Prev     string    `json:"prev"`
+	Hash     string    `json:"hash"`
+}
+
+// _Head 最后一条记录的序号和哈希，单独保存用于发现日志尾部被截断；key为密钥的标识，用于发现换了密钥
+type _Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Log 只追加的审计日志
+type Log struct {
+	mutex sync.Mutex
+	file  string
+	key   []byte
+	head  _Head
+	reset *Entry // 本次打开时开始新一段的记录
+}
+
+// EventChainReset 打开时日志无法解析或校验失败(被修改、截断、删除head文件或换了密钥)，
+// 已有日志归档后以该记录开始新的一段哈希链，target为上一段最后一条的哈希，result为原因
+const EventChainReset = "tamper.chain_reset"
+
+// Open 打开审计日志，已有日志校验不通过时归档并开始新的一段，不会因日志损坏而停止记录
+func Open(file string, key []byte) (*Log, base.Result) {
+	if len(key) == 0 {
+		return nil, base.INVALID_PARAM.SetMsg("audit key required")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("create audit directory failed", err)
+	}
+
+	l := &Log{file: file, key: key, head: _Head{Key: keyId(key)}}
+	entries, res := l.readAll()
+	if res.IsOk() {
+		_, res = l.Verify()
+	}
+	if res.IsOk() {
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			l.head.Seq, l.head.Hash = last.Seq, last.Hash
+		}
+		return l, base.SUCCESS
+	}
+
+	// 上一段的最后一条以head文件为准，head文件缺失或损坏时取日志中能解析的最后一条
+	prev, _ := l.loadHead()
+	if prev.Hash == "" && len(entries) > 0 {
+		prev.Hash = entries[len(entries)-1].Hash
+	}
+	archive, archiveRes := l.archive()
+	if !archiveRes.IsOk() {
+		return nil, archiveRes
+	}
+	logger.Warnw("audit log verification failed, archived and started a new chain", res, "file", file, "archive", archive)
+	entry := l.append("", EventChainReset, "system", prev.Hash, res.Message()+", archived to "+filepath.Base(archive))
+	l.reset = &entry
+	return l, base.SUCCESS
+}
+
+// VerifyFile 校验审计日志文件，不打开日志(不会归档)，返回记录数和最后一条的哈希
+func VerifyFile(file string, key []byte) (int, string, base.Result) {
+	l := &Log{file: file, key: key}
+	count, res := l.Verify()
+	if !res.IsOk() {
+		return count, "", res
+	}
+	head, _ := l.loadHead()
+	return count, head.Hash, base.SUCCESS
+}
+
+// File 日志文件路径
+func (l *Log) File() string {
+	return l.file
+}
+
+// Reset 本次打开时日志校验失败而开始新一段时返回该段的第一条记录，否则为nil
+func (l *Log) Reset() *Entry {
+	return l.reset
+}
+
+// Append 追加一条记录，写入失败只记录日志，不影响业务
+func (l *Log) Append(clientId, event, actor, target, result string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.append(clientId, event, actor, target, result)
+}
+
+func (l *Log) append(clientId, event, actor, target, result string) Entry {
+	entry := Entry{
+		Seq:      l.head.Seq + 1,
+		Time:     time.Now(),
+		ClientId: clientId,
+		Event:    event,
+		Actor:    actor,
+		Target:   target,
+		Result:   result,
+		Prev:     l.head.Hash,
+	}
+	entry.Hash = hashEntry(l.key, entry)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Warnw("marshal audit entry failed", err)
+		return entry
+	}
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logger.Warnw("open audit log failed", err, "file", l.file)
+		return entry
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		logger.Warnw("write audit log failed", err, "file", l.file)
+		return entry
+	}
+
+	l.head.Seq, l.head.Hash = entry.Seq, entry.Hash
+	if res := l.saveHead(); !res.IsOk() {
+		logger.Warnw("save audit head failed", res)
+	}
+	return entry
+}
+
+// Query 按客户端及时间范围[from, to)查询，clientId为空时查询所有客户端，时间为零值时不限制
+func (l *Log) Query(clientId string, from, to time.Time) ([]Entry, base.Result) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries, res := l.readAll()
+	if !res.IsOk() {
+		return nil, res
+	}
+	list := []Entry{}
+	for _, entry := range entries {
+		if clientId != "" && entry.ClientId != clientId {
+			continue
+		}
+		if (!from.IsZero() && entry.Time.Before(from)) || (!to.IsZero() && !entry.Time.Before(to)) {
+			continue
+		}
+		list = append(list, entry)
+	}
+	return list, base.SUCCESS
+}
+
+// Verify 校验哈希链及序号，并与单独保存的最后一条记录比较以发现截断，返回校验通过的记录数
+func (l *Log) Verify() (int, base.Result) {
+	entries, res := l.readAll()
+	if !res.IsOk() {
+		return 0, res
+	}
+	head, res := l.loadHead()
+	if !res.IsOk() {
+		return 0, res
+	}
+	if head.Key != "" && head.Key != keyId(l.key) {
+		return 0, base.ACTION_ILLEGAL.SetMsg("log was written with another key")
+	}
+
+	prev := ""
+	for i, entry := range entries {
+		if entry.Seq != uint64(i+1) {
+			return i, base.ACTION_ILLEGAL.SetMsg(fmt.Sprintf("entry %d: expected seq %d, got %d (entries removed)", i+1, i+1, entry.Seq))
+		}
+		if entry.Prev != prev {
+			return i, base.ACTION_ILLEGAL.SetMsg(fmt.Sprintf("entry %d: chain broken", entry.Seq))
+		}
+		if !hmac.Equal([]byte(hashEntry(l.key, entry)), []byte(entry.Hash)) {
+			return i, base.ACTION_ILLEGAL.SetMsg(fmt.Sprintf("entry %d: hash mismatch (entry modified or wrong key)", entry.Seq))
+		}
+		prev = entry.Hash
+	}
+
+	last := _Head{}
+	if len(entries) > 0 {
+		last = _Head{Seq: entries[len(entries)-1].Seq, Hash: prev}
+	}
+	if head.Seq != last.Seq || head.Hash != last.Hash {
+		return len(entries), base.ACTION_ILLEGAL.SetMsg(fmt.Sprintf("log ends at entry %d but head is entry %d (log truncated)", last.Seq, head.Seq))
+	}
+	return len(entries), base.SUCCESS
+}
+
+// readAll 读取所有记录，解析失败时同时返回失败前已解析的记录
+func (l *Log) readAll() ([]Entry, base.Result) {
+	f, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("open audit log failed", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, base.INVALID_PARAM.AppendErr(fmt.Sprintf("parse audit log line %d failed", line), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read audit log failed", err)
+	}
+	return entries, base.SUCCESS
+}
+
+// archive 把日志及其head文件改名为<file>.<时间>(重名时再加序号)，返回归档后的日志路径
+func (l *Log) archive() (string, base.Result) {
+	archive := l.file + "." + time.Now().Format("20060102150405")
+	for i := 1; fileExists(archive) || fileExists(headFile(archive)); i++ {
+		archive = fmt.Sprintf("%s.%s-%d", l.file, time.Now().Format("20060102150405"), i)
+	}
+	if err := os.Rename(l.file, archive); err != nil && !os.IsNotExist(err) {
+		return "", base.INTERNAL_ERROR.AppendErr("archive audit log failed", err)
+	}
+	if err := os.Rename(headFile(l.file), headFile(archive)); err != nil && !os.IsNotExist(err) {
+		return "", base.INTERNAL_ERROR.AppendErr("archive audit head failed", err)
+	}
+	return archive, base.SUCCESS
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func headFile(file string) string {
+	return file + ".head"
+}
+
+func (l *Log) loadHead() (_Head, base.Result) {
+	head := _Head{}
+	data, err := os.ReadFile(headFile(l.file))
+	if os.IsNotExist(err) {
+		return head, base.SUCCESS
+	} else if err != nil {
+		return head, base.INTERNAL_ERROR.AppendErr("read audit head failed", err)
+	}
+	if err = json.Unmarshal(data, &head); err != nil {
+		return head, base.INVALID_PARAM.AppendErr("parse audit head failed", err)
+	}
+	return head, base.SUCCESS
+}
+
+func (l *Log) saveHead() base.Result {
+	data, err := json.Marshal(l.head)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal audit head failed", err)
+	}
+	if err = os.WriteFile(headFile(l.file), data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write audit head failed", err)
+	}
+	return base.SUCCESS
+}
+
+func hashEntry(key []byte, entry Entry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(entry.Prev))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyId 密钥的标识，记录在head中，不泄露密钥本身
+func keyId(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("audit key id"))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
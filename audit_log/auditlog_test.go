@@ -0,0 +1,201 @@
+package auditlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("device-1.0123456789abcdef")
+
+// writeTestLog 写入3条记录，返回日志路径
+func writeTestLog(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, res := Open(file, testKey)
+	if !res.IsOk() {
+		t.Fatalf("Open: %s", res.Message())
+	}
+	l.Append("bob", "unlock", "tag:kitchen", "water", "ok")
+	l.Append("bob", "snooze", "user", "water", "10m")
+	l.Append("bob", "unlock", "code", "eye", "ok")
+	return file
+}
+
+func readLines(t *testing.T, file string) []string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, file string, lines []string) {
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    []byte
+		tamper func(t *testing.T, file string)
+		count  int
+		ok     bool
+		msg    string
+	}{
+		{"intact", testKey, func(t *testing.T, file string) {}, 3, true, ""},
+		{"modified", testKey, func(t *testing.T, file string) {
+			lines := readLines(t, file)
+			lines[1] = strings.Replace(lines[1], `"10m"`, `"1m"`, 1)
+			writeLines(t, file, lines)
+		}, 1, false, "entry 2: hash mismatch"},
+		{"removed", testKey, func(t *testing.T, file string) {
+			lines := readLines(t, file)
+			writeLines(t, file, append(lines[:1:1], lines[2:]...))
+		}, 1, false, "entries removed"},
+		{"truncated", testKey, func(t *testing.T, file string) {
+			lines := readLines(t, file)
+			writeLines(t, file, lines[:2])
+		}, 2, false, "log truncated"},
+		{"head removed", testKey, func(t *testing.T, file string) {
+			os.Remove(headFile(file))
+		}, 3, false, "log truncated"},
+		{"wrong key", []byte("device-2.fedcba9876543210"), func(t *testing.T, file string) {}, 0, false, "another key"},
+		{"wrong key without head", []byte("device-2.fedcba9876543210"), func(t *testing.T, file string) {
+			os.Remove(headFile(file))
+		}, 0, false, "entry 1: hash mismatch"},
+	}
+	for _, c := range cases {
+		file := writeTestLog(t)
+		c.tamper(t, file)
+		count, _, res := VerifyFile(file, c.key)
+		if res.IsOk() != c.ok || count != c.count || !strings.Contains(res.Message(), c.msg) {
+			t.Errorf("%s: VerifyFile = %d, %v (%s); want %d, %v (%s)", c.name, count, res.IsOk(), res.Message(), c.count, c.ok, c.msg)
+		}
+	}
+}
+
+// 没有密钥时改了记录也无法重算哈希链
+func TestVerifyRehashedWithoutKey(t *testing.T) {
+	file := writeTestLog(t)
+	forged := filepath.Join(t.TempDir(), "audit.log")
+	l, _ := Open(forged, []byte("guessed"))
+	l.Append("bob", "unlock", "tag:kitchen", "water", "ok")
+	os.Rename(forged, file)
+	os.Rename(headFile(forged), headFile(file))
+
+	if _, _, res := VerifyFile(file, testKey); res.IsOk() {
+		t.Errorf("log rebuilt with another key passed verification")
+	}
+}
+
+func TestOpenIntactLog(t *testing.T) {
+	file := writeTestLog(t)
+	l, res := Open(file, testKey)
+	if !res.IsOk() || l.Reset() != nil {
+		t.Fatalf("Open intact log = %v, %s; want no reset", l.Reset(), res.Message())
+	}
+	l.Append("bob", "unlock", "ack", "eye", "ok")
+	if count, _, res := VerifyFile(file, testKey); !res.IsOk() || count != 4 {
+		t.Errorf("VerifyFile = %d, %s; want 4 intact entries", count, res.Message())
+	}
+}
+
+// 日志损坏、截断、head文件被删或换了密钥时，归档后以chain_reset开始新的一段，不停止记录
+func TestOpenResetsBrokenChain(t *testing.T) {
+	newKey := []byte("device-2.fedcba9876543210")
+	cases := []struct {
+		name   string
+		key    []byte
+		tamper func(t *testing.T, file string)
+		reason string
+	}{
+		{"malformed line", testKey, func(t *testing.T, file string) {
+			lines := readLines(t, file)
+			writeLines(t, file, append(lines[:2:2], "not json\n"))
+		}, "parse audit log line 3"},
+		{"head removed", testKey, func(t *testing.T, file string) {
+			os.Remove(headFile(file))
+		}, "log truncated"},
+		{"log removed", testKey, func(t *testing.T, file string) {
+			os.Remove(file)
+		}, "log truncated"},
+		{"modified", testKey, func(t *testing.T, file string) {
+			lines := readLines(t, file)
+			lines[0] = strings.Replace(lines[0], `"ok"`, `"no"`, 1)
+			writeLines(t, file, lines)
+		}, "entry 1: hash mismatch"},
+		{"key changed", newKey, func(t *testing.T, file string) {}, "another key"},
+	}
+	for _, c := range cases {
+		file := writeTestLog(t)
+		_, prevHead, _ := VerifyFile(file, testKey)
+		c.tamper(t, file)
+
+		l, res := Open(file, c.key)
+		if !res.IsOk() {
+			t.Errorf("%s: Open failed: %s", c.name, res.Message())
+			continue
+		}
+		reset := l.Reset()
+		if reset == nil || reset.Event != EventChainReset || reset.Seq != 1 || !strings.Contains(reset.Result, c.reason) {
+			t.Errorf("%s: reset = %+v, want %s with %q", c.name, reset, EventChainReset, c.reason)
+			continue
+		}
+		if reset.Target != prevHead {
+			t.Errorf("%s: reset target = %q, want previous head %q", c.name, reset.Target, prevHead)
+		}
+		l.Append("bob", "unlock", "ack", "eye", "ok")
+		if count, _, res := VerifyFile(file, c.key); !res.IsOk() || count != 2 {
+			t.Errorf("%s: new chain = %d, %s; want 2 intact entries", c.name, count, res.Message())
+		}
+		// 日志被删时只有head文件可归档
+		if archives, _ := filepath.Glob(file + ".2*"); len(archives) == 0 {
+			t.Errorf("%s: nothing archived", c.name)
+		}
+	}
+}
+
+func TestOpenKeepsEveryArchive(t *testing.T) {
+	file := writeTestLog(t)
+	for i := 0; i < 3; i++ {
+		os.Remove(headFile(file))
+		if _, res := Open(file, testKey); !res.IsOk() {
+			t.Fatalf("Open: %s", res.Message())
+		}
+	}
+	if archives, _ := filepath.Glob(file + ".*[0-9]"); len(archives) != 3 {
+		t.Errorf("archives = %v, want three", archives)
+	}
+}
+
+func TestOpenRequiresKey(t *testing.T) {
+	if _, res := Open(filepath.Join(t.TempDir(), "audit.log"), nil); res.IsOk() {
+		t.Errorf("Open accepted an empty key")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	file := writeTestLog(t)
+	l, _ := Open(file, testKey)
+	l.Append("alice", "unlock", "code", "water", "ok")
+
+	cases := []struct {
+		clientId string
+		count    int
+	}{
+		{"", 4},
+		{"bob", 3},
+		{"alice", 1},
+		{"carol", 0},
+	}
+	for _, c := range cases {
+		entries, res := l.Query(c.clientId, time.Time{}, time.Time{})
+		if !res.IsOk() || len(entries) != c.count {
+			t.Errorf("Query(%q) = %d entries, %s; want %d", c.clientId, len(entries), res.Message(), c.count)
+		}
+	}
+}
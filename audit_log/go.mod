@@ -0,0 +1,21 @@
+module lx/funny/hydrate/audit_log
+
+go 1.20
+
+require (
+	github.com/livekit/protocol v1.9.2
+	github.com/patstar123/go-base v0.0.0-20240725150736-c1449eee9305
+)
+
+require (
+	github.com/go-logr/logr v1.3.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.7 // indirect
+	github.com/zeebo/xxh3 v1.0.2 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	go.uber.org/zap v1.26.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
+)
+
+replace github.com/livekit/protocol => github.com/patstar123/livekit-protocol v1.9.3-0.20240702145848-852ae9fe6821
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	auditlog "lx/funny/hydrate/audit_log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 审计事件
+const (
+	AuditUnlock       = "unlock"        // 客户端确认解除了提醒(或完成了多标签任务的一步)
+	AuditUnlockReject = "unlock.reject" // 解除提醒的请求被拒绝(标签未登记/签名错误/凭证无效，或客户端校验未通过)
+	AuditTag          = "tag."          // 标签登记表变更(tag.create/label/revoke/rotate)，target为标签ID
+	AuditDevice       = "device."       // 设备配对/作废(device.pair/revoke)，target为设备ID
+	AuditToken        = "token."        // 令牌签发/作废(token.issue/revoke)，target为令牌ID
+)
+
+// AuditEntry 审计记录，与监测客户端共用同一种哈希链，以服务端密钥(audit_key)计算HMAC
+type AuditEntry = auditlog.Entry
+
+// AuditLog 只追加的审计日志(每行一条json)
+type AuditLog = auditlog.Log
+
+// OpenAuditLog 打开审计日志，密钥文件不存在时生成
+func OpenAuditLog(file, keyFile string) (*AuditLog, base.Result) {
+	key, res := loadAuditKey(keyFile, true)
+	if !res.IsOk() {
+		return nil, res
+	}
+	return auditlog.Open(file, key)
+}
+
+// loadAuditKey 读取审计日志的密钥(hex)，create时不存在则生成
+func loadAuditKey(keyFile string, create bool) ([]byte, base.Result) {
+	data, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("generate audit key failed", err)
+		}
+		if err = os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0600); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("write audit key failed", err)
+		}
+		logger.Infow("audit key generated", "file", keyFile)
+		return key, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read audit key failed", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < 16 {
+		return nil, base.INVALID_PARAM.SetMsg("invalid audit key: " + keyFile)
+	}
+	return key, base.SUCCESS
+}
+
+// auditActor 记录中的操作者，请求已通过认证
+func auditActor(principal *_Principal, r *http.Request) string {
+	if principal != nil {
+		return principal.Scope + ":" + principal.Id
+	}
+	if name, _, ok := r.BasicAuth(); ok {
+		return "account:" + name
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		id, _, _ := strings.Cut(token, ".")
+		return "bearer:" + id
+	}
+	if tagId := r.URL.Query().Get("tag"); tagId != "" {
+		return "tag:" + tagId
+	}
+	if r.URL.Query().Get("code") != "" {
+		return "code"
+	}
+	return "anonymous"
+}
+
+// handleAudit 按客户端及时间范围查询审计日志，需监护人令牌，管理员可不指定clientId
+// GET /audit?clientId=xx&from=2024-01-01T00:00:00Z&to=...，时间也可以是unix秒
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientId := query.Get("clientId")
+	if _, ok := requireScope(w, r, clientId, ScopeGuardian); !ok {
+		return
+	}
+
+	from, res := parseAuditTime(query.Get("from"))
+	if !res.IsOk() {
+		writeResult(w, res)
+		return
+	}
+	to, res := parseAuditTime(query.Get("to"))
+	if !res.IsOk() {
+		writeResult(w, res)
+		return
+	}
+
+	entries, res := audit.Query(clientId, from, to)
+	if !res.IsOk() {
+		writeResult(w, res)
+		return
+	}
+	writeResult(w, base.SUCCESS.SetData(entries))
+}
+
+func auditResult(res base.Result) string {
+	if res.IsOk() {
+		return "ok"
+	}
+	return res.Message()
+}
+
+func parseAuditTime(value string) (time.Time, base.Result) {
+	if value == "" {
+		return time.Time{}, base.SUCCESS
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), base.SUCCESS
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, base.INVALID_PARAM.SetMsg("invalid time: " + value)
+	}
+	return t, base.SUCCESS
+}
+
+// verifyAuditCommand msg_router audit-verify：校验审计日志，有问题时以非0退出
+func verifyAuditCommand() int {
+	key, res := loadAuditKey(config.AuditKey, false)
+	if !res.IsOk() {
+		fmt.Printf("audit key %s is unavailable: %s\n", config.AuditKey, res.Message())
+		return 1
+	}
+	count, head, res := auditlog.VerifyFile(config.AuditLog, key)
+	if !res.IsOk() {
+		fmt.Printf("audit log %s is NOT intact after %d entries: %s\n", config.AuditLog, count, res.Message())
+		return 1
+	}
+	fmt.Printf("audit log %s is intact: %d entries, head %s\n", config.AuditLog, count, head)
+	return 0
+}
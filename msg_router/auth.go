@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 令牌的权限范围
+const (
+	ScopeDevice   = "device"   // 监测客户端，由配对得到，只能订阅消息、同步本客户端的标签
+	ScopeGuardian = "guardian" // 监护人，可远程解除提醒、管理本客户端的标签
+	ScopeAdmin    = "admin"    // 管理员，可操作所有客户端
+)
+
+// TokenInfo 监护人/管理员令牌，只保存令牌的哈希
+type TokenInfo struct {
+	Id         string    `json:"id"`
+	Scope      string    `json:"scope"`
+	ClientId   string    `json:"clientId"` // admin令牌为空
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash,omitempty"`
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// _Principal 通过认证的调用方
+type _Principal struct {
+	Id       string // 令牌或设备ID
+	Scope    string
+	ClientId string
+}
+
+// canAccess 调用方能否以scopes中的权限操作clientId
+func (p *_Principal) canAccess(clientId string, scopes ...string) bool {
+	if p.Scope == ScopeAdmin {
+		return true
+	}
+	return p.ClientId == clientId && containsString(scopes, p.Scope)
+}
+
+// TokenStore 监护人/管理员令牌，保存在json文件中，设备令牌由DeviceStore管理
+type TokenStore struct {
+	mutex  sync.Mutex
+	file   string
+	tokens map[string]*TokenInfo // tokenId -> token
+}
+
+func LoadTokenStore(file string) (*TokenStore, base.Result) {
+	s := &TokenStore{file: file, tokens: map[string]*TokenInfo{}}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read token store failed", err)
+	}
+
+	if err = json.Unmarshal(data, &s.tokens); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse token store failed", err)
+	}
+	return s, base.SUCCESS
+}
+
+// Issue 签发令牌，返回的令牌(tokenId.secret)只出现这一次
+func (s *TokenStore) Issue(scope, clientId, name string) (*TokenInfo, string, base.Result) {
+	if scope != ScopeGuardian && scope != ScopeAdmin {
+		return nil, "", base.INVALID_PARAM.SetMsg("scope must be guardian or admin")
+	}
+	if scope == ScopeGuardian && clientId == "" {
+		return nil, "", base.INVALID_PARAM.SetMsg("clientId is required for guardian token")
+	}
+	if scope == ScopeAdmin {
+		clientId = ""
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", base.INTERNAL_ERROR.AppendErr("generate token id failed", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", base.INTERNAL_ERROR.AppendErr("generate token failed", err)
+	}
+	token := id + "." + secret
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := &TokenInfo{
+		Id:        id,
+		Scope:     scope,
+		ClientId:  clientId,
+		Name:      name,
+		Hash:      hashCredential(token),
+		CreatedAt: time.Now(),
+	}
+	s.tokens[id] = info
+	if res := s.save(); !res.IsOk() {
+		delete(s.tokens, id)
+		return nil, "", res
+	}
+
+	view := *info
+	view.Hash = ""
+	return &view, token, base.SUCCESS
+}
+
+// Authenticate 校验令牌，未知的令牌ID返回nil和SUCCESS，交由设备凭证校验
+func (s *TokenStore) Authenticate(token string) (*_Principal, base.Result) {
+	id, _, _ := strings.Cut(token, ".")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, ok := s.tokens[id]
+	if !ok {
+		return nil, base.SUCCESS
+	}
+	if subtle.ConstantTimeCompare([]byte(info.Hash), []byte(hashCredential(token))) != 1 {
+		return nil, base.ACTION_ILLEGAL.SetMsg("invalid token")
+	}
+	if info.Revoked {
+		return nil, base.ACTION_ILLEGAL.SetMsg("token revoked")
+	}
+
+	info.LastUsedAt = time.Now()
+	if res := s.save(); !res.IsOk() {
+		logger.Warnw("save token last used failed", res)
+	}
+	return &_Principal{Id: info.Id, Scope: info.Scope, ClientId: info.ClientId}, base.SUCCESS
+}
+
+// List 返回令牌(不含哈希)，clientId为空时返回全部
+func (s *TokenStore) List(clientId string) []TokenInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []TokenInfo{}
+	for _, info := range s.tokens {
+		if clientId == "" || info.ClientId == clientId {
+			view := *info
+			view.Hash = ""
+			list = append(list, view)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke 作废令牌，clientId非空时只能作废该客户端的令牌
+func (s *TokenStore) Revoke(clientId, id string) base.Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, ok := s.tokens[id]
+	if !ok || (clientId != "" && info.ClientId != clientId) {
+		return base.INVALID_PARAM.SetMsg("unknown token: " + id)
+	}
+	info.Revoked = true
+	return s.save()
+}
+
+func (s *TokenStore) save() base.Result {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal token store failed", err)
+	}
+	if err = os.WriteFile(s.file, data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write token store failed", err)
+	}
+	return base.SUCCESS
+}
+
+// authenticate 校验请求的Bearer令牌(监护人/管理员令牌或设备凭证)，未携带令牌时返回nil和SUCCESS
+func authenticate(r *http.Request) (*_Principal, base.Result) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, base.SUCCESS
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return nil, base.ACTION_ILLEGAL.SetMsg("bearer token required")
+	}
+
+	principal, res := tokens.Authenticate(token)
+	if !res.IsOk() || principal != nil {
+		return principal, res
+	}
+
+	device, res := devices.Authenticate(token)
+	if !res.IsOk() {
+		return nil, res
+	}
+	return &_Principal{Id: device.Id, Scope: ScopeDevice, ClientId: device.ClientId}, base.SUCCESS
+}
+
+// requireScope 要求请求携带可以操作clientId的令牌，失败时已写入401/403响应
+func requireScope(w http.ResponseWriter, r *http.Request, clientId string, scopes ...string) (*_Principal, bool) {
+	principal, res := authenticate(r)
+	if !res.IsOk() {
+		writeUnauthorized(w, res)
+		return nil, false
+	}
+	if principal == nil {
+		writeUnauthorized(w, base.ACTION_ILLEGAL.SetMsg("bearer token required"))
+		return nil, false
+	}
+	if !principal.canAccess(clientId, scopes...) {
+		writeForbidden(w, principal)
+		return nil, false
+	}
+	return principal, true
+}
+
+func writeUnauthorized(w http.ResponseWriter, res base.Result) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="msg_router"`)
+	writeResultStatus(w, http.StatusUnauthorized, res)
+}
+
+func writeForbidden(w http.ResponseWriter, principal *_Principal) {
+	logger.Warnw("forbidden request", nil, "principal", principal.Id, "scope", principal.Scope)
+	writeResultStatus(w, http.StatusForbidden, base.ACTION_ILLEGAL.SetMsg("insufficient scope"))
+}
+
+// handleTokens 签发/列出/作废令牌，需账号basic认证或管理员令牌
+// GET /tokens，POST /tokens/issue?scope=guardian|admin&name=xx[&clientId=xx]，POST /tokens/revoke?id=xx
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	clientId, isAdmin, ok := authOwner(w, r)
+	if !ok {
+		return
+	}
+
+	// 管理员可以查看/作废所有令牌
+	owner := clientId
+	if isAdmin {
+		owner = ""
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet:
+		writeResult(w, base.SUCCESS.SetData(tokens.List(owner)))
+	case strings.HasSuffix(r.URL.Path, "/issue"):
+		scope := query.Get("scope")
+		if scope == ScopeAdmin && !isAdmin {
+			writeResultStatus(w, http.StatusForbidden, base.ACTION_ILLEGAL.SetMsg("only admin can issue admin token"))
+			return
+		}
+		target := clientId
+		if isAdmin && query.Get("clientId") != "" {
+			target = query.Get("clientId")
+		}
+		info, token, res := tokens.Issue(scope, target, query.Get("name"))
+		if !res.IsOk() {
+			writeResult(w, res)
+			return
+		}
+		logger.Infow("token issued", "id", info.Id, "scope", info.Scope, "clientId", info.ClientId)
+		audit.Append(info.ClientId, AuditToken+"issue", auditActor(nil, r), info.Id, info.Scope)
+		writeResult(w, base.SUCCESS.SetData(map[string]any{"token": token, "info": info}))
+	default:
+		res := tokens.Revoke(owner, query.Get("id"))
+		logger.Infow("token revoke", "id", query.Get("id"), "code", res.Code())
+		audit.Append(owner, AuditToken+"revoke", auditActor(nil, r), query.Get("id"), auditResult(res))
+		writeResult(w, res)
+	}
+}
+
+// authOwner 账号basic认证或管理员令牌，返回账号名(管理员为空)及是否管理员，失败时已写入401/403响应
+func authOwner(w http.ResponseWriter, r *http.Request) (clientId string, isAdmin bool, ok bool) {
+	if _, _, basic := r.BasicAuth(); basic {
+		name, res := authAccount(r)
+		if !res.IsOk() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="msg_router"`)
+			writeResultStatus(w, http.StatusUnauthorized, res)
+			return "", false, false
+		}
+		return name, isAdminAccount(name), true
+	}
+
+	principal, ok := requireScope(w, r, "", ScopeAdmin)
+	if !ok {
+		return "", false, false
+	}
+	return principal.ClientId, true, true
+}
+
+func isAdminAccount(name string) bool {
+	for _, account := range config.Accounts {
+		if account.Name == name {
+			return account.Admin
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
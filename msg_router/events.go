@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditMonitor 监测客户端上报的停止运行/时间篡改事件(monitor.offline/suspended/clock_jump)，result为事件描述
+const AuditMonitor = "monitor."
+
+// GuardianEvent 推送给监护人的事件
+type GuardianEvent struct {
+	ClientId   string    `json:"clientId"`
+	Type       string    `json:"type"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	WorkingSec int       `json:"workingSec"`
+	Message    string    `json:"message"`
+}
+
+// handleEvents 监测客户端上报事件，监护人查询事件
+// POST /events?clientId=xx&type=offline&from=unix&to=unix&workingSec=xx&message=xx，需设备凭证
+// GET /events?clientId=xx[&from=...&to=...]，需监护人令牌
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientId := query.Get("clientId")
+
+	if r.Method == http.MethodGet {
+		if _, ok := requireScope(w, r, clientId, ScopeGuardian); !ok {
+			return
+		}
+		from, res := parseAuditTime(query.Get("from"))
+		if !res.IsOk() {
+			writeResult(w, res)
+			return
+		}
+		to, res := parseAuditTime(query.Get("to"))
+		if !res.IsOk() {
+			writeResult(w, res)
+			return
+		}
+		entries, res := audit.Query(clientId, from, to)
+		if !res.IsOk() {
+			writeResult(w, res)
+			return
+		}
+		events := []AuditEntry{}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Event, AuditMonitor) {
+				events = append(events, entry)
+			}
+		}
+		writeResult(w, base.SUCCESS.SetData(events))
+		return
+	}
+
+	principal, ok := requireScope(w, r, clientId, ScopeDevice)
+	if !ok {
+		return
+	}
+	event := GuardianEvent{ClientId: clientId, Type: query.Get("type"), Message: query.Get("message")}
+	if event.ClientId == "" || event.Type == "" || event.Message == "" {
+		writeResult(w, base.INVALID_PARAM.SetMsg("clientId, type and message are required"))
+		return
+	}
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+	event.From, event.To = time.Unix(from, 0), time.Unix(to, 0)
+	event.WorkingSec, _ = strconv.Atoi(query.Get("workingSec"))
+
+	logger.Infow("guardian event", "clientId", clientId, "type", event.Type, "message", event.Message)
+	audit.Append(clientId, AuditMonitor+event.Type, auditActor(principal, r), "", event.Message)
+	go pushGuardianEvent(event)
+	writeResult(w, base.SUCCESS)
+}
+
+// pushGuardianEvent 把事件以json POST到event_webhook(如ntfy、企业微信机器人的转发服务)
+func pushGuardianEvent(event GuardianEvent) {
+	if config.EventWebhook == "" {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Warnw("marshal guardian event failed", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	rsp, err := client.Post(config.EventWebhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger.Warnw("push guardian event failed", err, "clientId", event.ClientId)
+		return
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		logger.Warnw("push guardian event failed", nil, "clientId", event.ClientId, "status", rsp.StatusCode)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/patstar123/go-base"
 	bu "github.com/patstar123/go-base/utils"
 	"net/http"
+	"os"
 	"sync"
 
 	"github.com/gorilla/mux"
@@ -18,6 +19,10 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 var config _Config
+var registry *TagRegistry
+var devices *DeviceStore
+var tokens *TokenStore
+var audit *AuditLog
 
 type Client struct {
 	id       string
@@ -26,6 +31,10 @@ type Client struct {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		os.Exit(hashPasswordCommand())
+	}
+
 	loadBuilding()
 
 	res := loadConfigFile("config.yaml")
@@ -34,21 +43,86 @@ func main() {
 		return
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		os.Exit(verifyAuditCommand())
+	}
+
 	base.InitLogger("msg", &config.Logging)
 	logger.Infow("loadConfigFile", "config", config)
+	checkAccounts()
+	if config.AllowUnpaired {
+		logger.Warnw("allow_unpaired is on: unpaired monitors and signed tags that are not registered are accepted, "+
+			"set it to false once all monitors are paired", nil)
+	}
+
+	registry, res = LoadTagRegistry(config.TagStore)
+	if !res.IsOk() {
+		logger.Warnw("LoadTagRegistry failed", res)
+		return
+	}
+
+	devices, res = LoadDeviceStore(config.DeviceStore)
+	if !res.IsOk() {
+		logger.Warnw("LoadDeviceStore failed", res)
+		return
+	}
+
+	tokens, res = LoadTokenStore(config.TokenStore)
+	if !res.IsOk() {
+		logger.Warnw("LoadTokenStore failed", res)
+		return
+	}
+
+	audit, res = OpenAuditLog(config.AuditLog, config.AuditKey)
+	if !res.IsOk() {
+		logger.Warnw("OpenAuditLog failed", res)
+		return
+	}
+	if reset := audit.Reset(); reset != nil {
+		go pushGuardianEvent(GuardianEvent{Type: "audit_reset", From: reset.Time, To: reset.Time,
+			Message: "router audit log was reset: " + reset.Result})
+	}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/sub_msg", handleConnections)
 	r.HandleFunc("/reset_remind", handleResetRemind).Methods("POST", "GET")
+	r.HandleFunc("/tags", handleTags).Methods("GET")
+	r.HandleFunc("/tags/{action}", handleTagAction).Methods("POST")
+	r.HandleFunc("/pair/start", handlePairStart).Methods("POST")
+	r.HandleFunc("/pair/confirm", handlePairConfirm).Methods("POST")
+	r.HandleFunc("/pair/poll", handlePairPoll).Methods("GET")
+	r.HandleFunc("/devices", handleDevices).Methods("GET")
+	r.HandleFunc("/devices/revoke", handleDevices).Methods("POST")
+	r.HandleFunc("/tokens", handleTokens).Methods("GET")
+	r.HandleFunc("/tokens/issue", handleTokens).Methods("POST")
+	r.HandleFunc("/tokens/revoke", handleTokens).Methods("POST")
+	r.HandleFunc("/audit", handleAudit).Methods("GET")
+	r.HandleFunc("/events", handleEvents).Methods("GET", "POST")
 
 	http.Handle("/", r)
-	err := http.ListenAndServe(":"+config.ApiPort, nil)
+	err := listenAndServe(":"+config.ApiPort, nil)
 	if err != nil {
-		logger.Warnw("http.ListenAndServe failed", err)
+		logger.Warnw("listenAndServe failed", err)
 	}
 }
 
 func handleConnections(w http.ResponseWriter, r *http.Request) {
+	// 配对过的设备通过Authorization: Bearer <设备凭证>连接
+	principal, res := authenticate(r)
+	if !res.IsOk() {
+		logger.Warnw("reject device connection", res)
+		writeUnauthorized(w, res)
+		return
+	}
+	if principal == nil && !config.AllowUnpaired {
+		writeUnauthorized(w, base.ACTION_ILLEGAL.SetMsg("device credential required"))
+		return
+	}
+	if principal != nil && principal.Scope != ScopeDevice {
+		writeForbidden(w, principal)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Warnw("Upgrade websocket failed", err)
@@ -63,6 +137,16 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if principal != nil && principal.ClientId != clientId {
+		logger.Warnw("client id mismatches device", nil, "clientId", clientId, "device", principal.Id)
+		return
+	}
+	if principal == nil && devices.HasDevices(clientId) {
+		// 已配对的客户端不再接受仅凭客户端ID的连接
+		logger.Warnw("reject unpaired connection", nil, "clientId", clientId)
+		return
+	}
+
 	logger.Infof("Client %s connected", clientId)
 
 	client := &Client{
@@ -101,16 +185,31 @@ func handleResetRemind(w http.ResponseWriter, r *http.Request) {
 	}
 
 	clientId := clientIds[0]
+	principal, ok := authResetRemind(w, r, clientId)
+	if !ok {
+		return
+	}
+	actor, target := auditActor(principal, r), r.URL.Query().Get("name")
+
 	lock.Lock()
 	client, ok := clients[clientId]
 	lock.Unlock()
 
 	if !ok {
+		audit.Append(clientId, AuditUnlockReject, actor, target, "client not connected")
 		http.Error(w, "Client not connected", http.StatusNotFound)
 		return
 	}
 
-	err := client.ws.WriteMessage(websocket.TextMessage, []byte("reset_remind"))
+	// 其余参数(如tag/code/name)原样转发，供客户端校验
+	query := r.URL.Query()
+	query.Del("clientId")
+	command := "reset_remind"
+	if len(query) > 0 {
+		command += "?" + query.Encode()
+	}
+
+	err := client.ws.WriteMessage(websocket.TextMessage, []byte(command))
 	if err != nil {
 		http.Error(w, "Failed to send message to client", http.StatusInternalServerError)
 		return
@@ -122,7 +221,66 @@ func handleResetRemind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(message)
+	res := clientReply(message)
+	if res.IsOk() {
+		audit.Append(clientId, AuditUnlock, actor, target, auditResult(res))
+	} else {
+		audit.Append(clientId, AuditUnlockReject, actor, target, auditResult(res))
+	}
+	w.Write([]byte(res.Message()))
+}
+
+// clientReply 解析客户端对reset_remind的回复，旧版客户端只回复文本，仅"Good boy"表示已解除
+func clientReply(message []byte) base.Result {
+	if err, res := base.UnmarshalJson(message); err == nil {
+		return res
+	}
+	if string(message) == "Good boy" {
+		return base.SUCCESS.SetMsg(string(message))
+	}
+	return base.ACTION_ILLEGAL.SetMsg(string(message))
+}
+
+// authResetRemind 监护人令牌可远程解除提醒；手机扫描时无法携带令牌，以标签签名或二维码校验码作为凭证
+// 返回通过认证的令牌，以标签或校验码为凭证时为nil
+func authResetRemind(w http.ResponseWriter, r *http.Request, clientId string) (*_Principal, bool) {
+	principal, res := authenticate(r)
+	if !res.IsOk() {
+		audit.Append(clientId, AuditUnlockReject, auditActor(nil, r), r.URL.Query().Get("name"), res.Message())
+		writeUnauthorized(w, res)
+		return nil, false
+	}
+	if principal != nil {
+		if !principal.canAccess(clientId, ScopeGuardian) {
+			audit.Append(clientId, AuditUnlockReject, auditActor(principal, r), r.URL.Query().Get("name"), "insufficient scope")
+			writeForbidden(w, principal)
+			return nil, false
+		}
+		return principal, true
+	}
+
+	query := r.URL.Query()
+	tagId, sig := query.Get("tag"), query.Get("sig")
+	if tagId != "" && (registry.HasTags(clientId) || !config.AllowUnpaired) {
+		if res = registry.Verify(clientId, tagId, sig); !res.IsOk() || !registry.HasTags(clientId) {
+			if res.IsOk() {
+				res = base.ACTION_ILLEGAL.SetMsg("unknown tag")
+			}
+			logger.Warnw("reject tag scan", res, "clientId", clientId, "tag", tagId)
+			audit.Append(clientId, AuditUnlockReject, auditActor(nil, r), query.Get("name"), res.Message())
+			writeResultStatus(w, http.StatusForbidden, res)
+			return nil, false
+		}
+		return nil, true
+	}
+	// 兼容模式下未登记的标签也必须带签名，由客户端校验；二维码校验码由客户端校验
+	if (tagId != "" && sig != "") || query.Get("code") != "" {
+		return nil, true
+	}
+
+	audit.Append(clientId, AuditUnlockReject, auditActor(nil, r), query.Get("name"), "bearer token, signed tag or code required")
+	writeUnauthorized(w, base.ACTION_ILLEGAL.SetMsg("bearer token, signed tag or code required"))
+	return nil, false
 }
 
 func closeWs(client *Client) {
@@ -135,7 +293,21 @@ func closeWs(client *Client) {
 }
 
 type _Config struct {
-	ApiPort string        `yaml:"api_port" json:"apiPort"`
+	ApiPort  string `yaml:"api_port" json:"apiPort"`
+	TagStore string `yaml:"tag_store" json:"tagStore"` // 标签登记表文件
+
+	Accounts      []_Account `yaml:"accounts" json:"-"`
+	DeviceStore   string     `yaml:"device_store" json:"deviceStore"`      // 已配对设备文件
+	PairingTtlSec int        `yaml:"pairing_ttl_sec" json:"pairingTtlSec"` // 配对码有效期
+	TokenStore    string     `yaml:"token_store" json:"tokenStore"`        // 监护人/管理员令牌文件
+	AllowUnpaired bool       `yaml:"allow_unpaired" json:"allowUnpaired"`  // 兼容未配对的客户端及未登记(但带签名)的标签，仅在升级过渡期内开启
+
+	Tls      _TlsConfig `yaml:"tls" json:"tls"`
+	AuditLog string     `yaml:"audit_log" json:"auditLog"` // 审计日志文件(只追加，哈希链)
+	AuditKey string     `yaml:"audit_key" json:"auditKey"` // 计算审计日志哈希链的密钥文件，不存在时生成
+
+	EventWebhook string `yaml:"event_webhook" json:"eventWebhook"` // 监测客户端停止运行等事件的推送地址
+
 	Logging logger.Config `yaml:"logging,omitempty" json:"-"`
 }
 
@@ -148,6 +320,25 @@ func loadConfigFile(configFile string) base.Result {
 	if config.ApiPort == "" {
 		config.ApiPort = "28081"
 	}
+	if config.TagStore == "" {
+		config.TagStore = "tags.db.json"
+	}
+	if config.DeviceStore == "" {
+		config.DeviceStore = "devices.db.json"
+	}
+	config.Tls.fillDefaults()
+	if config.TokenStore == "" {
+		config.TokenStore = "tokens.db.json"
+	}
+	if config.AuditLog == "" {
+		config.AuditLog = "audit.log"
+	}
+	if config.AuditKey == "" {
+		config.AuditKey = "audit.key"
+	}
+	if config.PairingTtlSec <= 0 {
+		config.PairingTtlSec = 10 * 60
+	}
 
 	return base.SUCCESS
 }
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pairCodeAlphabet 配对码字符集，去掉了容易混淆的0/O/1/I
+const pairCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+type _Account struct {
+	Name           string `yaml:"name"`            // 账号名，即客户端ID
+	PasswordHash   string `yaml:"password_hash"`   // 密码的bcrypt哈希(含随机盐)，用 msg_router hash-password 生成
+	PasswordSha256 string `yaml:"password_sha256"` // 旧版本的无盐sha256，不再接受，仅用于启动时提示改用password_hash
+	Admin          bool   `yaml:"admin"`           // 管理员账号可签发admin令牌、操作所有客户端
+}
+
+// dummyPasswordHash 账号不存在时也做一次bcrypt比较，避免通过响应时间猜出账号名
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("hydrate-now"), bcrypt.DefaultCost)
+
+// DeviceInfo 已配对的监测客户端，只保存凭证的哈希
+type DeviceInfo struct {
+	Id             string    `json:"id"`
+	ClientId       string    `json:"clientId"`
+	Name           string    `json:"name"`
+	CredentialHash string    `json:"credentialHash,omitempty"`
+	Revoked        bool      `json:"revoked"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastSeenAt     time.Time `json:"lastSeenAt"`
+}
+
+// PairResult 配对完成后交给监测客户端的凭证，只能取走一次
+type PairResult struct {
+	Status     string `json:"status"` // pending/paired
+	ClientId   string `json:"clientId,omitempty"`
+	DeviceId   string `json:"deviceId,omitempty"`
+	Credential string `json:"credential,omitempty"`
+	TotpSecret string `json:"totpSecret,omitempty"` // 与监护人共享的离线解锁码密钥
+}
+
+type _Pairing struct {
+	code       string
+	token      string // 监测客户端轮询配对结果用
+	deviceName string
+	expiresAt  time.Time
+	result     *PairResult
+}
+
+// DeviceStore 已配对设备及进行中的配对，设备保存在json文件中
+type DeviceStore struct {
+	mutex    sync.Mutex
+	file     string
+	devices  map[string]*DeviceInfo // deviceId -> device
+	pairings map[string]*_Pairing   // code -> pairing
+}
+
+func LoadDeviceStore(file string) (*DeviceStore, base.Result) {
+	s := &DeviceStore{file: file, devices: map[string]*DeviceInfo{}, pairings: map[string]*_Pairing{}}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read device store failed", err)
+	}
+
+	if err = json.Unmarshal(data, &s.devices); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse device store failed", err)
+	}
+	return s, base.SUCCESS
+}
+
+// StartPairing 监测客户端申请配对码，配对码需在有效期内由账号确认
+func (s *DeviceStore) StartPairing(deviceName string) (*_Pairing, base.Result) {
+	code, err := randomCode(8)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("generate pairing code failed", err)
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("generate pairing token failed", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.dropExpired(time.Now())
+	p := &_Pairing{
+		code:       code,
+		token:      token,
+		deviceName: deviceName,
+		expiresAt:  time.Now().Add(time.Duration(config.PairingTtlSec) * time.Second),
+	}
+	s.pairings[code] = p
+	return p, base.SUCCESS
+}
+
+// ConfirmPairing 账号确认配对码，为设备生成凭证和离线解锁码密钥
+func (s *DeviceStore) ConfirmPairing(clientId, code string) (*DeviceInfo, string, base.Result) {
+	code = strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+
+	deviceId, err := randomHex(8)
+	if err != nil {
+		return nil, "", base.INTERNAL_ERROR.AppendErr("generate device id failed", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", base.INTERNAL_ERROR.AppendErr("generate credential failed", err)
+	}
+	totp := make([]byte, 20)
+	if _, err = rand.Read(totp); err != nil {
+		return nil, "", base.INTERNAL_ERROR.AppendErr("generate totp secret failed", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.dropExpired(time.Now())
+	p, ok := s.pairings[code]
+	if !ok || p.result != nil {
+		return nil, "", base.INVALID_PARAM.SetMsg("unknown or expired pairing code")
+	}
+
+	device := &DeviceInfo{
+		Id:        deviceId,
+		ClientId:  clientId,
+		Name:      p.deviceName,
+		CreatedAt: time.Now(),
+	}
+	credential := deviceId + "." + secret
+	device.CredentialHash = hashCredential(credential)
+	s.devices[deviceId] = device
+	if res := s.save(); !res.IsOk() {
+		delete(s.devices, deviceId)
+		return nil, "", res
+	}
+
+	p.result = &PairResult{
+		Status:     "paired",
+		ClientId:   clientId,
+		DeviceId:   deviceId,
+		Credential: credential,
+		TotpSecret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(totp),
+	}
+	return device, p.result.TotpSecret, base.SUCCESS
+}
+
+// PollPairing 监测客户端查询配对结果，确认后返回凭证并结束配对
+func (s *DeviceStore) PollPairing(token string) (*PairResult, base.Result) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.dropExpired(time.Now())
+	for code, p := range s.pairings {
+		if subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) != 1 {
+			continue
+		}
+		if p.result == nil {
+			return &PairResult{Status: "pending"}, base.SUCCESS
+		}
+		delete(s.pairings, code)
+		return p.result, base.SUCCESS
+	}
+	return nil, base.ACTION_ILLEGAL.SetMsg("unknown or expired pairing")
+}
+
+// Authenticate 校验设备凭证(deviceId.secret)，返回对应的设备
+func (s *DeviceStore) Authenticate(credential string) (*DeviceInfo, base.Result) {
+	deviceId, _, _ := strings.Cut(credential, ".")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	device, ok := s.devices[deviceId]
+	if !ok || subtle.ConstantTimeCompare([]byte(device.CredentialHash), []byte(hashCredential(credential))) != 1 {
+		return nil, base.ACTION_ILLEGAL.SetMsg("invalid device credential")
+	}
+	if device.Revoked {
+		return nil, base.ACTION_ILLEGAL.SetMsg("device revoked")
+	}
+
+	device.LastSeenAt = time.Now()
+	if res := s.save(); !res.IsOk() {
+		logger.Warnw("save device last seen failed", res)
+	}
+	info := *device
+	return &info, base.SUCCESS
+}
+
+// HasDevices 客户端是否已有配对的设备，有则不再接受未带凭证的连接
+func (s *DeviceStore) HasDevices(clientId string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, device := range s.devices {
+		if device.ClientId == clientId && !device.Revoked {
+			return true
+		}
+	}
+	return false
+}
+
+// List 返回客户端已配对的设备(不含凭证哈希)
+func (s *DeviceStore) List(clientId string) []DeviceInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []DeviceInfo{}
+	for _, device := range s.devices {
+		if device.ClientId == clientId {
+			info := *device
+			info.CredentialHash = ""
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke 作废设备凭证，该设备需重新配对
+func (s *DeviceStore) Revoke(clientId, deviceId string) base.Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	device, ok := s.devices[deviceId]
+	if !ok || device.ClientId != clientId {
+		return base.INVALID_PARAM.SetMsg("unknown device: " + deviceId)
+	}
+	device.Revoked = true
+	return s.save()
+}
+
+func (s *DeviceStore) dropExpired(now time.Time) {
+	for code, p := range s.pairings {
+		if now.After(p.expiresAt) {
+			delete(s.pairings, code)
+		}
+	}
+}
+
+func (s *DeviceStore) save() base.Result {
+	data, err := json.MarshalIndent(s.devices, "", "  ")
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal device store failed", err)
+	}
+	if err = os.WriteFile(s.file, data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write device store failed", err)
+	}
+	return base.SUCCESS
+}
+
+func hashCredential(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i := range buf {
+		buf[i] = pairCodeAlphabet[int(buf[i])%len(pairCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// authAccount 按http basic认证校验账号，返回账号名
+func authAccount(r *http.Request) (string, base.Result) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", base.ACTION_ILLEGAL.SetMsg("account authentication required")
+	}
+
+	hash := dummyPasswordHash
+	for _, account := range config.Accounts {
+		if account.Name == name && account.PasswordHash != "" {
+			hash = []byte(account.PasswordHash)
+			break
+		}
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", base.ACTION_ILLEGAL.SetMsg("invalid account or password")
+	}
+	return name, base.SUCCESS
+}
+
+// checkAccounts 只配置了旧版password_sha256的账号无法登录，启动时提示
+func checkAccounts() {
+	for _, account := range config.Accounts {
+		if account.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(account.PasswordHash)); err != nil {
+				logger.Warnw("invalid password_hash, the account cannot log in", err, "account", account.Name)
+			}
+		} else if account.PasswordSha256 != "" {
+			logger.Warnw("password_sha256 is no longer accepted, the account cannot log in; "+
+				"replace it with password_hash from: msg_router hash-password", nil, "account", account.Name)
+		}
+	}
+}
+
+// hashPasswordCommand msg_router hash-password 从标准输入读取密码，输出供accounts.password_hash使用的bcrypt哈希
+func hashPasswordCommand() int {
+	fmt.Fprint(os.Stderr, "password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "read password failed:", err)
+		return 1
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hash password failed:", err)
+		return 1
+	}
+	fmt.Println(string(hash))
+	return 0
+}
+
+// handlePairStart POST /pair/start?name=xx 监测客户端申请配对码
+func handlePairStart(w http.ResponseWriter, r *http.Request) {
+	p, res := devices.StartPairing(r.URL.Query().Get("name"))
+	if !res.IsOk() {
+		writeResult(w, res)
+		return
+	}
+
+	logger.Infow("pairing started", "device", p.deviceName)
+	writeResult(w, base.SUCCESS.SetData(map[string]any{
+		"code":      p.code[:4] + "-" + p.code[4:],
+		"token":     p.token,
+		"expiresIn": config.PairingTtlSec,
+	}))
+}
+
+// handlePairConfirm POST /pair/confirm?code=xx 账号(basic认证)确认配对码，返回给监护人的离线解锁码密钥
+func handlePairConfirm(w http.ResponseWriter, r *http.Request) {
+	clientId, res := authAccount(r)
+	if !res.IsOk() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="msg_router"`)
+		writeResultStatus(w, http.StatusUnauthorized, res)
+		return
+	}
+
+	device, totpSecret, res := devices.ConfirmPairing(clientId, r.URL.Query().Get("code"))
+	if !res.IsOk() {
+		audit.Append(clientId, AuditDevice+"pair", auditActor(nil, r), "", auditResult(res))
+		writeResult(w, res)
+		return
+	}
+
+	logger.Infow("device paired", "clientId", clientId, "device", device.Id, "name", device.Name)
+	audit.Append(clientId, AuditDevice+"pair", auditActor(nil, r), device.Id, auditResult(res))
+	query := url.Values{"secret": {totpSecret}, "issuer": {"HydrateNow"}}
+	writeResult(w, base.SUCCESS.SetData(map[string]any{
+		"deviceId":   device.Id,
+		"clientId":   clientId,
+		"totpSecret": totpSecret,
+		"otpauth":    "otpauth://totp/HydrateNow:" + url.PathEscape(clientId) + "?" + query.Encode(),
+	}))
+}
+
+// handlePairPoll GET /pair/poll?token=xx 监测客户端查询配对结果
+func handlePairPoll(w http.ResponseWriter, r *http.Request) {
+	result, res := devices.PollPairing(r.URL.Query().Get("token"))
+	if !res.IsOk() {
+		writeResult(w, res)
+		return
+	}
+	writeResult(w, base.SUCCESS.SetData(result))
+}
+
+// handleDevices GET /devices 列出已配对的设备，POST /devices/revoke?id=xx 作废设备，需账号basic认证或管理员令牌(需指定clientId)
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+	clientId, isAdmin, ok := authOwner(w, r)
+	if !ok {
+		return
+	}
+	if isAdmin && r.URL.Query().Get("clientId") != "" {
+		clientId = r.URL.Query().Get("clientId")
+	}
+
+	if r.Method == http.MethodGet {
+		writeResult(w, base.SUCCESS.SetData(devices.List(clientId)))
+		return
+	}
+
+	deviceId := r.URL.Query().Get("id")
+	res := devices.Revoke(clientId, deviceId)
+	logger.Infow("device revoke", "clientId", clientId, "device", deviceId, "code", res.Code())
+	audit.Append(clientId, AuditDevice+"revoke", auditActor(nil, r), deviceId, auditResult(res))
+	writeResult(w, res)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagInfo 一个已登记的标签，secret用于校验标签地址中的sig
+type TagInfo struct {
+	Id         string    `json:"id"`
+	Location   string    `json:"location"`
+	Secret     string    `json:"secret,omitempty"`
+	Sig        string    `json:"sig,omitempty"`
+	SigHash    string    `json:"sigHash,omitempty"` // 只在列出时返回，客户端用于校验直接扫描的标签，无法用来签名
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastScanAt time.Time `json:"lastScanAt"`
+}
+
+// TagRegistry 按客户端登记的标签，保存在json文件中
+type TagRegistry struct {
+	mutex sync.Mutex
+	file  string
+	tags  map[string]map[string]*TagInfo // clientId -> tagId -> tag
+}
+
+func LoadTagRegistry(file string) (*TagRegistry, base.Result) {
+	g := &TagRegistry{file: file, tags: map[string]map[string]*TagInfo{}}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return g, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read tag registry failed", err)
+	}
+
+	if err = json.Unmarshal(data, &g.tags); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse tag registry failed", err)
+	}
+	return g, base.SUCCESS
+}
+
+// List 返回客户端的所有标签(按ID排序)，withSecret为false时不包含密钥
+func (g *TagRegistry) List(clientId string, withSecret bool) []TagInfo {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	list := []TagInfo{}
+	for _, tag := range g.tags[clientId] {
+		info := *tag
+		sig := signTag(tag.Secret, clientId, tag.Id)
+		info.SigHash = tagSigHash(clientId, tag.Id, sig)
+		if withSecret {
+			info.Sig = sig
+		} else {
+			info.Secret = ""
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// Create 登记一个新标签，ID由位置名加随机后缀组成，secret为空时随机生成
+func (g *TagRegistry) Create(clientId, location, secret string) (TagInfo, base.Result) {
+	if clientId == "" || location == "" {
+		return TagInfo{}, base.INVALID_PARAM.SetMsg("clientId and location are required")
+	}
+
+	suffix, err := randomHex(3)
+	if err != nil {
+		return TagInfo{}, base.INTERNAL_ERROR.AppendErr("generate tag id failed", err)
+	}
+	secret, res := tagSecret(secret)
+	if !res.IsOk() {
+		return TagInfo{}, res
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tag := &TagInfo{
+		Id:        location + "-" + suffix,
+		Location:  location,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	if g.tags[clientId] == nil {
+		g.tags[clientId] = map[string]*TagInfo{}
+	}
+	g.tags[clientId][tag.Id] = tag
+	if res := g.save(); !res.IsOk() {
+		return TagInfo{}, res
+	}
+
+	info := *tag
+	info.Sig = signTag(tag.Secret, clientId, tag.Id)
+	return info, base.SUCCESS
+}
+
+// Label 修改标签的位置名称
+func (g *TagRegistry) Label(clientId, tagId, location string) base.Result {
+	if location == "" {
+		return base.INVALID_PARAM.SetMsg("location is required")
+	}
+	return g.update(clientId, tagId, func(tag *TagInfo) { tag.Location = location })
+}
+
+// Revoke 作废丢失或不再使用的标签，之后扫描该标签将被拒绝
+func (g *TagRegistry) Revoke(clientId, tagId string) base.Result {
+	return g.update(clientId, tagId, func(tag *TagInfo) { tag.Revoked = true })
+}
+
+// RotateSecret 更换标签的密钥，旧的标签地址随之失效，需要重新写入标签，secret为空时随机生成
+func (g *TagRegistry) RotateSecret(clientId, tagId, secret string) (TagInfo, base.Result) {
+	secret, res := tagSecret(secret)
+	if !res.IsOk() {
+		return TagInfo{}, res
+	}
+
+	var info TagInfo
+	res = g.update(clientId, tagId, func(tag *TagInfo) {
+		tag.Secret = secret
+		info = *tag
+		info.Sig = signTag(secret, clientId, tagId)
+	})
+	return info, res
+}
+
+// HasTags 客户端是否登记过标签
+func (g *TagRegistry) HasTags(clientId string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.tags[clientId]) > 0
+}
+
+// Verify 校验扫描的标签并记录扫描时间，客户端未登记任何标签时不做校验
+func (g *TagRegistry) Verify(clientId, tagId, sig string) base.Result {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tags := g.tags[clientId]
+	if len(tags) == 0 {
+		return base.SUCCESS
+	}
+
+	tag, ok := tags[tagId]
+	if !ok {
+		return base.ACTION_ILLEGAL.SetMsg("unknown tag")
+	}
+	if tag.Revoked {
+		return base.ACTION_ILLEGAL.SetMsg("tag revoked")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signTag(tag.Secret, clientId, tagId))) {
+		return base.ACTION_ILLEGAL.SetMsg("invalid tag signature")
+	}
+
+	tag.LastScanAt = time.Now()
+	if res := g.save(); !res.IsOk() {
+		logger.Warnw("save last scan time failed", res)
+	}
+	return base.SUCCESS
+}
+
+func (g *TagRegistry) update(clientId, tagId string, fn func(tag *TagInfo)) base.Result {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tag, ok := g.tags[clientId][tagId]
+	if !ok {
+		return base.INVALID_PARAM.SetMsg("unknown tag: " + tagId)
+	}
+	fn(tag)
+	return g.save()
+}
+
+func (g *TagRegistry) save() base.Result {
+	data, err := json.MarshalIndent(g.tags, "", "  ")
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal tag registry failed", err)
+	}
+
+	// 包含标签密钥，只允许当前用户读写
+	if err = os.WriteFile(g.file, data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write tag registry failed", err)
+	}
+	return base.SUCCESS
+}
+
+// signTag 标签地址中的签名，防止他人仿造标签ID，需与tag_maker保持一致
+func signTag(secret, clientId, tagId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(clientId + "|" + tagId))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// tagSigHash 签名的哈希，可以校验签名但不能用来生成签名，需与pc_monitor保持一致
+func tagSigHash(clientId, tagId, sig string) string {
+	sum := sha256.Sum256([]byte(clientId + "|" + tagId + "|" + sig))
+	return hex.EncodeToString(sum[:])
+}
+
+// tagSecret 校验调用方(tag_maker)生成的密钥，为空时随机生成
+func tagSecret(secret string) (string, base.Result) {
+	if secret == "" {
+		secret, err := randomHex(32)
+		if err != nil {
+			return "", base.INTERNAL_ERROR.AppendErr("generate secret failed", err)
+		}
+		return secret, base.SUCCESS
+	}
+	if _, err := hex.DecodeString(secret); err != nil || len(secret) < 32 {
+		return "", base.INVALID_PARAM.SetMsg("secret must be at least 16 bytes in hex")
+	}
+	return secret, base.SUCCESS
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleTags GET /tags?clientId=xx[&secrets=1] 列出标签，需设备/监护人令牌，密钥只返回给管理员，设备只需要sigHash
+func handleTags(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	principal, ok := requireScope(w, r, query.Get("clientId"), ScopeDevice, ScopeGuardian)
+	if !ok {
+		return
+	}
+
+	withSecret := query.Get("secrets") == "1"
+	if withSecret && principal.Scope != ScopeAdmin {
+		writeForbidden(w, principal)
+		return
+	}
+	writeResult(w, base.SUCCESS.SetData(registry.List(query.Get("clientId"), withSecret)))
+}
+
+// handleTagAction POST /tags/{action}?clientId=xx&tag=xx[&location=xx]，action为create/label/revoke/rotate，需监护人令牌
+// create/rotate可在表单中提交tag_maker生成的secret
+func handleTagAction(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientId, tagId := query.Get("clientId"), query.Get("tag")
+	principal, ok := requireScope(w, r, clientId, ScopeGuardian)
+	if !ok {
+		return
+	}
+
+	var res base.Result
+	action := mux.Vars(r)["action"]
+	switch action {
+	case "create":
+		var info TagInfo
+		if info, res = registry.Create(clientId, query.Get("location"), r.PostFormValue("secret")); res.IsOk() {
+			res = res.SetData(info)
+			tagId = info.Id
+		}
+	case "label":
+		res = registry.Label(clientId, tagId, query.Get("location"))
+	case "revoke":
+		res = registry.Revoke(clientId, tagId)
+	case "rotate":
+		var info TagInfo
+		if info, res = registry.RotateSecret(clientId, tagId, r.PostFormValue("secret")); res.IsOk() {
+			res = res.SetData(info)
+		}
+	default:
+		writeResult(w, base.INVALID_PARAM.SetMsg("unsupported action: "+action))
+		return
+	}
+
+	logger.Infow("tag action", "clientId", clientId, "tag", tagId, "action", action, "code", res.Code())
+	audit.Append(clientId, AuditTag+action, auditActor(principal, r), tagId, auditResult(res))
+	writeResult(w, res)
+}
+
+func writeResult(w http.ResponseWriter, res base.Result) {
+	status := http.StatusOK
+	if !res.IsOk() {
+		status = http.StatusBadRequest
+	}
+	writeResultStatus(w, status, res)
+}
+
+func writeResultStatus(w http.ResponseWriter, status int, res base.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type _TlsConfig struct {
+	CertFile   string   `yaml:"cert_file" json:"certFile"`     // 证书文件(PEM)
+	KeyFile    string   `yaml:"key_file" json:"keyFile"`       // 私钥文件(PEM)
+	SelfSigned bool     `yaml:"self_signed" json:"selfSigned"` // 证书文件不存在时自动生成自签名证书，供局域网使用
+	Hosts      []string `yaml:"hosts" json:"hosts"`            // 自签名证书包含的域名/IP
+	MinVersion string   `yaml:"min_version" json:"minVersion"` // 最低TLS版本: 1.2(默认)、1.3
+}
+
+func (c *_TlsConfig) enabled() bool {
+	return c.CertFile != "" || c.SelfSigned
+}
+
+func (c *_TlsConfig) fillDefaults() {
+	if c.SelfSigned && c.CertFile == "" {
+		c.CertFile = "self_signed.crt"
+	}
+	if c.SelfSigned && c.KeyFile == "" {
+		c.KeyFile = "self_signed.key"
+	}
+	if c.MinVersion == "" {
+		c.MinVersion = "1.2"
+	}
+}
+
+// listenAndServe 配置了证书时以https/wss提供服务，否则为http/ws
+func listenAndServe(addr string, handler http.Handler) error {
+	if !config.Tls.enabled() {
+		logger.Warnw("tls is not configured, client ids and unlocks are sent in cleartext", nil)
+		return http.ListenAndServe(addr, handler)
+	}
+
+	tlsConfig, res := buildTlsConfig(&config.Tls)
+	if !res.IsOk() {
+		return res
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	return server.ListenAndServeTLS("", "")
+}
+
+func buildTlsConfig(cfg *_TlsConfig) (*tls.Config, base.Result) {
+	minVersion := map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}[cfg.MinVersion]
+	if minVersion == 0 {
+		return nil, base.INVALID_PARAM.SetMsg("unsupported tls min_version: " + cfg.MinVersion)
+	}
+
+	if cfg.SelfSigned {
+		if _, err := os.Stat(cfg.CertFile); os.IsNotExist(err) {
+			if res := generateSelfSigned(cfg); !res.IsOk() {
+				return nil, res
+			}
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("load tls certificate failed", err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	logger.Infow("tls enabled", "cert", cfg.CertFile, "sha256", hex.EncodeToString(sum[:]), "minVersion", cfg.MinVersion)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}, base.SUCCESS
+}
+
+// generateSelfSigned 生成自签名证书，该证书同时作为CA，需分发给客户端(router_tls.ca_file)用于校验
+func generateSelfSigned(cfg *_TlsConfig) base.Result {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("generate tls key failed", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("generate serial number failed", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "msg_router", Organization: []string{"HydrateNow"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	hosts := cfg.Hosts
+	if len(hosts) == 0 {
+		hosts = defaultCertHosts()
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("create certificate failed", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal tls key failed", err)
+	}
+
+	if err = os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write certificate failed", err)
+	}
+	if err = os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write tls key failed", err)
+	}
+
+	logger.Infow("generated self-signed certificate", "cert", cfg.CertFile, "hosts", hosts)
+	return base.SUCCESS
+}
+
+// defaultCertHosts 未配置hosts时包含本机名和各网卡的非回环地址，局域网内的客户端才能通过校验
+func defaultCertHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logger.Warnw("list network interfaces failed", err)
+		return hosts
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
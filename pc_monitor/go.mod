@@ -13,10 +13,14 @@ require (
 	github.com/patstar123/go-base v0.0.0-20240725150736-c1449eee9305
 	go.uber.org/zap v1.26.0
 	golang.org/x/sys v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+	lx/funny/hydrate/audit_log v0.0.0
 )
 
 replace github.com/livekit/protocol => github.com/patstar123/livekit-protocol v1.9.3-0.20240702145848-852ae9fe6821
 
+replace lx/funny/hydrate/audit_log => ../audit_log
+
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
 	github.com/bytedance/sonic/loader v0.1.1 // indirect
@@ -64,5 +68,4 @@ require (
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
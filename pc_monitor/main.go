@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/juju/fslock"
 	"github.com/livekit/protocol/logger"
@@ -9,48 +10,149 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
 var IsTrayBuilding = "true"
 
-var commands = map[string][]any{
-	"": {"Run this program as service", run},
+// command 子命令，args为用法中命令后的参数，帮助按声明顺序输出
+type command struct {
+	name string
+	args string
+	desc string
+	run  func(loadBuilding func())
+}
+
+var commands = []command{
+	{"", "", "Run this program as service (or tray, depending on the build)", run},
+	{"run", "", "Run this program directly instead of as service", runDirectly},
+
+	{"autostart-on", "", "Add auto start to regedit", pkg.AddAutoStart},
+	{"autostart-off", "", "Remove auto start from regedit", pkg.RemoveAutoStart},
+
+	{"install", "", "Install this service", pkg.InstallService},
+	{"uninstall", "", "Uninstall this service", pkg.UninstallService},
+	{"start", "", "Start this service", pkg.StartService},
+	{"stop", "", "Stop this service", pkg.StopService},
+	{"restart", "", "Restart this service", pkg.RestartService},
+	{"status", "", "Query the status of this service", pkg.QueryService},
 
-	"run": {"Run this program directly instead of as service", runDirectly},
+	{"info", "[--json]", "Show time to the next break, overdue reminders and today's quotas of the running monitor", pkg.CliInfo},
+	{"snooze", "[--min 10] [--name water] [--json]", "Snooze due reminders of the running monitor", pkg.CliSnooze},
+	{"pause", "--min 60 [--json]", "Pause the timers of the running monitor", pkg.CliPause},
+	{"resume", "", "Resume the paused monitor", pkg.CliResume},
+	{"history", "[--since 24h] [--event unlock] [--json]", "List audit events of the running monitor", pkg.CliHistory},
+	{"scan", "--tag id [--sig xx] | --code xx [--name water] [--json]", "Simulate a tag or QR code scan for testing", pkg.CliScan},
+
+	{"totp-new", "", "Generate a TOTP secret for the guardian unlock code", pkg.NewTotpSecret},
+	{"pair", "", "Pair this device with the router account", pkg.PairDevice},
+	{"unpair", "", "Remove the saved device credential", pkg.UnpairDevice},
+
+	{"config", "show [--effective] [--json]", "Print the merged config; --effective adds defaults and the source of each value", pkg.ConfigShow},
+	{"audit-verify", "", "Verify the local audit log has not been edited or truncated", pkg.VerifyAudit},
+	{"watchdog", "", "Launch the monitor and restart it when it crashes, is killed or hangs", pkg.RunWatchdog},
+
+	{"help", "[command]", "Show help for all commands or one command", nil},
+}
 
-	"autostart-on":  {"Add auto start to regedit", pkg.AddAutoStart},
-	"autostart-off": {"Remove auto start from regedit", pkg.RemoveAutoStart},
+// stringList 可重复的参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
 
-	"install":   {"Install this service", pkg.InstallService},
-	"uninstall": {"Uninstall this service", pkg.UninstallService},
-	"start":     {"Start this service", pkg.StartService},
-	"stop":      {"Stop this service", pkg.StopService},
-	"restart":   {"Restart this service", pkg.RestartService},
-	"status":    {"Restart this service", pkg.QueryService},
+func newGlobalFlagSet(opts *pkg.Options) *flag.FlagSet {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.StringVar(&opts.ConfigFile, "config", "", "config file (default config.yaml next to the executable)")
+	flags.StringVar(&opts.LogLevel, "log-level", "", "override logging.level in the config: debug, info, warn or error")
+	flags.StringVar(&opts.DataDir, "data-dir", "", "directory of the device credential, audit log, state and user config (default the user config directory)")
+	flags.Var((*stringList)(&opts.Sets), "set", "override a config value, e.g. --set pause.max_min=30 (repeatable)")
+	flags.SetOutput(os.Stdout)
+	flags.Usage = func() { printHelp(flags) }
+	return flags
 }
 
-func printAvailableCommands() {
-	fmt.Println("Available commands:")
-	for cmd, action := range commands {
-		fmt.Printf("  %s: %s\n", cmd, action[0].(string))
+func printHelp(flags *flag.FlagSet) {
+	fmt.Printf("Usage: %s [options] [command] [args]\n\nOptions:\n", filepath.Base(os.Args[0]))
+	flags.PrintDefaults()
+	fmt.Println("\nCommands:")
+	for _, cmd := range commands {
+		name := cmd.name
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Printf("  %-16s %s\n", name, cmd.desc)
 	}
+	fmt.Printf("\nRun '%s help <command>' for the arguments of a command.\n", filepath.Base(os.Args[0]))
+}
+
+func printCommandUsage(cmd *command) {
+	usage := strings.TrimSpace(cmd.name + " " + cmd.args)
+	fmt.Printf("Usage: %s [options] %s\n\n  %s\n", filepath.Base(os.Args[0]), usage, cmd.desc)
+}
+
+func isHelpArg(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
 }
 
 func main() {
-	command := ""
-	if len(os.Args) > 1 {
-		command = os.Args[1]
+	var opts pkg.Options
+	flags := newGlobalFlagSet(&opts)
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
 	}
 
-	action, exists := commands[command]
-	if !exists {
-		fmt.Printf("Unknown command: %s\n", command)
-		printAvailableCommands()
-		os.Exit(1)
+	name := flags.Arg(0)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Printf("Unknown command: %s\n\n", name)
+		printHelp(flags)
+		os.Exit(2)
+	}
+
+	var args []string
+	if flags.NArg() > 1 {
+		args = flags.Args()[1:]
+	}
+	if cmd.name == "help" {
+		if len(args) == 0 {
+			printHelp(flags)
+		} else if target := findCommand(args[0]); target != nil {
+			printCommandUsage(target)
+		} else {
+			fmt.Printf("Unknown command: %s\n", args[0])
+			os.Exit(2)
+		}
+		return
+	}
+	// 带参数的命令自己解析-h并输出参数说明
+	if len(args) > 0 && isHelpArg(args[0]) && cmd.args == "" {
+		printCommandUsage(cmd)
+		return
 	}
 
-	action[1].(func(loadBuilding func()))(func() {
+	opts.Args = args
+	pkg.SetOptions(opts)
+	cmd.run(func() {
 		loadBuilding()
 	})
 }
@@ -59,7 +161,7 @@ func run(loadBuilding func()) {
 	lock := getAppLock()
 	err := lock.TryLock()
 	if err != nil {
-		walk.MsgBox(nil, pkg.AppName, "请勿重复运行", walk.MsgBoxOK)
+		walk.MsgBox(nil, pkg.AppName, pkg.T("app.running"), walk.MsgBoxOK)
 		return
 	}
 	defer lock.Unlock()
@@ -75,7 +177,7 @@ func runDirectly(loadBuilding func()) {
 	lock := getAppLock()
 	err := lock.TryLock()
 	if err != nil {
-		walk.MsgBox(nil, pkg.AppName, "请勿重复运行", walk.MsgBoxOK)
+		walk.MsgBox(nil, pkg.AppName, pkg.T("app.running"), walk.MsgBoxOK)
 		return
 	}
 	defer lock.Unlock()
@@ -87,7 +189,7 @@ func runDirectly(loadBuilding func()) {
 
 	sender := pkg.NewMessageBoxSender(pkg.AppName)
 
-	if res := reminder.Init(pkg.ConfigFileName, nil, sender); !res.IsOk() {
+	if res := reminder.Init(pkg.ConfigFilePath(), nil, sender); !res.IsOk() {
 		logger.Warnw("reminder init with error", res)
 		return
 	}
@@ -105,7 +207,7 @@ func runDirectly(loadBuilding func()) {
 }
 
 func getAppLock() *fslock.Lock {
-	lockPath := filepath.Join(os.TempDir(), "hydrate_now.lock")
+	lockPath := pkg.AppLockPath()
 	logger.Infow("check app lock file: " + lockPath)
 	return fslock.New(lockPath)
 }
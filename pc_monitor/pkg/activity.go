@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ActivitySource 用户在电脑上的活动情况
+type ActivitySource interface {
+	// IdleDuration 返回距离最后一次键盘鼠标输入的时长，无法获取时ok为false
+	IdleDuration() (idle time.Duration, ok bool)
+}
+
+type _LastInputInfo struct {
+	cbSize uint32
+	dwTime uint32
+}
+
+// LastInputSource 通过GetLastInputInfo获取当前会话的最后输入时间，以服务方式运行(会话0)时无法获取
+type LastInputSource struct {
+	getLastInputInfo *syscall.LazyProc
+	getTickCount     *syscall.LazyProc
+	session0         bool
+}
+
+func NewLastInputSource() *LastInputSource {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	return &LastInputSource{
+		getLastInputInfo: syscall.NewLazyDLL("user32.dll").NewProc("GetLastInputInfo"),
+		getTickCount:     kernel32.NewProc("GetTickCount"),
+		session0:         inSession0(kernel32.NewProc("ProcessIdToSessionId")),
+	}
+}
+
+// inSession0 会话0中GetLastInputInfo返回的是服务会话而非用户的输入时间，空闲时长会一直增长
+func inSession0(processIdToSessionId *syscall.LazyProc) bool {
+	var session uint32
+	ret, _, _ := processIdToSessionId.Call(uintptr(os.Getpid()), uintptr(unsafe.Pointer(&session)))
+	return ret != 0 && session == 0
+}
+
+func (s *LastInputSource) IdleDuration() (time.Duration, bool) {
+	if s.session0 {
+		return 0, false
+	}
+
+	info := _LastInputInfo{cbSize: uint32(unsafe.Sizeof(_LastInputInfo{}))}
+	ret, _, _ := s.getLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if ret == 0 {
+		return 0, false
+	}
+
+	// 两者都是开机后的毫秒数(49.7天回绕)，无符号相减可正确处理回绕
+	now, _, _ := s.getTickCount.Call()
+	return time.Duration(uint32(now)-info.dwTime) * time.Millisecond, true
+}
+
+const maxAwaySpans = 16
+
+type _AwaySpan struct {
+	end    time.Time
+	length time.Duration
+}
+
+// AwayTracker 周期采样空闲时长，记录最近几段离开电脑(无输入)的时段
+type AwayTracker struct {
+	source   ActivitySource
+	minSpan  time.Duration // 短于该时长的空闲不记录
+	lastIdle time.Duration
+	spans    []_AwaySpan
+}
+
+func NewAwayTracker(source ActivitySource, minSpan time.Duration) *AwayTracker {
+	return &AwayTracker{source: source, minSpan: minSpan}
+}
+
+// Sample 采样一次，空闲时长变短说明上一段空闲已结束
+func (t *AwayTracker) Sample(now time.Time) {
+	idle, ok := t.source.IdleDuration()
+	if !ok {
+		return
+	}
+
+	if idle < t.lastIdle && t.lastIdle >= t.minSpan {
+		t.spans = append(t.spans, _AwaySpan{end: now.Add(-idle), length: t.lastIdle})
+		if len(t.spans) > maxAwaySpans {
+			t.spans = t.spans[len(t.spans)-maxAwaySpans:]
+		}
+	}
+	t.lastIdle = idle
+}
+
+// AwayAround 返回at时刻仍在持续、或在at之前grace内结束的最长离开时长，无法获取活动时ok为false
+func (t *AwayTracker) AwayAround(at time.Time, grace time.Duration) (away time.Duration, ok bool) {
+	away, ok = t.source.IdleDuration()
+	if !ok {
+		return 0, false
+	}
+
+	for _, span := range t.spans {
+		if !span.end.Before(at.Add(-grace)) && span.length > away {
+			away = span.length
+		}
+	}
+	return away, true
+}
@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ApiStatus GET /api/v1/status 的返回
+type ApiStatus struct {
+	ClientId      string           `json:"clientId"`
+	Reminders     []ReminderStatus `json:"reminders"`
+	Pause         PauseStatus      `json:"pause"`
+	Snooze        SnoozeStatus     `json:"snooze"`
+	Guardian      GuardianStatus   `json:"guardian"`
+	Pomodoro      PomodoroStatus   `json:"pomodoro"`
+	OffDuty       bool             `json:"offDuty"`
+	NextStart     time.Time        `json:"nextStart,omitempty"` // 非工作时段时为下个工作时段的开始时间
+	DeferredUntil time.Time        `json:"deferredUntil,omitempty"`
+	DeferredBy    string           `json:"deferredBy,omitempty"` // 推迟提醒的会议
+}
+
+// SnoozeStatus 今日剩余的稍后提醒次数及可选时长
+type SnoozeStatus struct {
+	Remaining    int   `json:"remaining"`
+	DurationsMin []int `json:"durationsMin"`
+}
+
+// initApi 供脚本、编辑器插件和命令行使用的本地接口，只接受本机访问
+func (r *HNReminder) initApi() {
+	api := r.http.Group("/api/v1", localOnly)
+	api.GET("/status", r.onReqApiStatusHandler)
+	api.POST("/pause", r.onReqApiPauseHandler)
+	api.POST("/resume", r.onReqApiResumeHandler)
+	api.POST("/snooze", r.onReqSnoozeHandler)
+	api.POST("/scan", r.onReqApiScanHandler)
+	api.GET("/history", r.onReqApiHistoryHandler)
+	api.GET("/config", r.onReqApiConfigHandler)
+}
+
+func localOnly(c *gin.Context) {
+	if ip := c.RemoteIP(); ip != "127.0.0.1" && ip != "::1" {
+		bu.ReturnRsp(c, http.StatusForbidden, base.ACTION_ILLEGAL.SetMsg("local access only"))
+		c.Abort()
+	}
+}
+
+// GetApiStatus 汇总提醒、暂停、稍后提醒、监护人解锁码等状态
+func (r *HNReminder) GetApiStatus() ApiStatus {
+	status := ApiStatus{
+		ClientId:  r.config.ClientId,
+		Reminders: r.GetReminderStatuses(),
+		Pause:     r.GetPauseStatus(),
+		Guardian:  r.GetGuardianStatus(),
+		Pomodoro:  r.GetPomodoroStatus(),
+	}
+	status.Snooze.Remaining, status.Snooze.DurationsMin = r.GetSnoozeStatus()
+	status.OffDuty, status.NextStart = r.GetOffDuty()
+	status.DeferredUntil, status.DeferredBy = r.GetDeferred()
+	return status
+}
+
+// onReqApiStatusHandler GET /api/v1/status
+func (r *HNReminder) onReqApiStatusHandler(c *gin.Context) {
+	bu.ReturnRsp(c, http.StatusOK, base.SUCCESS.SetData(r.GetApiStatus()))
+}
+
+// onReqApiPauseHandler POST /api/v1/pause?min=60
+func (r *HNReminder) onReqApiPauseHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	minutes, err := strconv.Atoi(c.Query("min"))
+	if err != nil {
+		bu.ReturnRsp(c, http.StatusBadRequest, base.INVALID_PARAM.AppendErr("invalid min", err))
+		return
+	}
+	res := r.Pause(minutes)
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+// onReqApiResumeHandler POST /api/v1/resume
+func (r *HNReminder) onReqApiResumeHandler(c *gin.Context) {
+	bu.LogHttpRequest(nil)
+
+	res := r.Resume()
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+// onReqApiScanHandler POST /api/v1/scan?tag=xx&sig=xx|code=xx[&name=water]，与/reset_remind相同，但按统一格式返回
+func (r *HNReminder) onReqApiScanHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	query := c.Request.URL.Query()
+	res := r.resetRemind(query.Get("name"), proofFromQuery(query))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	data, _ := res.Data().(gin.H)
+	if data != nil && res.Message() != "" {
+		data["progress"] = res.Message()
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+// onReqApiHistoryHandler GET /api/v1/history?from=...&to=...&event=unlock，返回审计日志中的记录
+// from/to为RFC3339或unix秒，默认最近24小时；event按前缀过滤
+func (r *HNReminder) onReqApiHistoryHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	from, res := parseAuditTime(c.Query("from"))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	to, res := parseAuditTime(c.Query("to"))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	if from.IsZero() {
+		from = time.Now().Add(-24 * time.Hour)
+	}
+	if r.auditLog == nil {
+		bu.ReturnRsp(c, http.StatusInternalServerError, base.INTERNAL_ERROR.SetMsg("audit log unavailable"))
+		return
+	}
+
+	entries, res := r.auditLog.Query("", from, to)
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusInternalServerError, res)
+		return
+	}
+	if event := c.Query("event"); event != "" {
+		filtered := []AuditEntry{}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Event, event) {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	bu.ReturnRsp(c, http.StatusOK, base.SUCCESS.SetData(entries))
+}
+
+// onReqApiConfigHandler GET /api/v1/config 返回生效的配置(已填充默认值)，不含密钥
+func (r *HNReminder) onReqApiConfigHandler(c *gin.Context) {
+	view, res := configView(&r.config)
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusInternalServerError, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, base.SUCCESS.SetData(view))
+}
+
+// configView 按yaml字段名把配置转为map，并隐藏密钥
+func configView(config *_Config) (map[string]any, base.Result) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("marshal config failed", err)
+	}
+	view := map[string]any{}
+	if err = yaml.Unmarshal(data, &view); err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("unmarshal config failed", err)
+	}
+
+	return redactConfig(view), base.SUCCESS
+}
+
+// redactConfig 隐藏配置中标记了secret:"true"的字段(按_Config的结构遍历)，复制后再改，不影响传入的映射
+func redactConfig(view map[string]any) map[string]any {
+	out, _ := redactValue(view, reflect.TypeOf(_Config{})).(map[string]any)
+	return out
+}
+
+func redactValue(value any, t reflect.Type) any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return value
+		}
+		out := make(map[string]any, len(fields))
+		for key, field := range fields {
+			out[key] = field
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			field, ok := out[name]
+			if name == "-" || !ok {
+				continue
+			}
+			if f.Tag.Get("secret") == "true" {
+				if !isEmptyValue(field) {
+					out[name] = "***"
+				}
+			} else {
+				out[name] = redactValue(field, f.Type)
+			}
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]any)
+		if !ok {
+			return value
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			out[i] = redactValue(item, t.Elem())
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// isEmptyValue 未配置的密钥保持原样，以便看出没有配置
+func isEmptyValue(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	}
+	return false
+}
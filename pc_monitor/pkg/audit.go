@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/patstar123/go-base"
+	auditlog "lx/funny/hydrate/audit_log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	auditFileName    = "audit.log"
+	auditKeyFileName = "audit.key"
+)
+
+// 审计事件
+const (
+	AuditUnlock       = "unlock"           // 提醒已解除，target为解除的提醒
+	AuditUnlockReject = "unlock.reject"    // 解除提醒的凭证被拒绝，result为原因
+	AuditScan         = "scan"             // 多步任务完成了一步(如按顺序扫描标签)，result为进度
+	AuditSnooze       = "snooze"           // 跳过本次休息(稍后提醒)，result为推迟的时长
+	AuditTamper       = "tamper."          // 停止运行或系统时间被调整(tamper.offline/suspended/clock_jump)
+	AuditWatchdog     = "watchdog.restart" // 守护进程重启了监测程序，result为原因
+	AuditPause        = "pause"            // 暂停计时，result为暂停的时长
+	AuditResume       = "resume"           // 结束暂停，actor为user或expired
+)
+
+// AuditActorGuardian 监护人报出的离线解锁码
+const AuditActorGuardian = "guardian"
+
+// AuditEntry 审计记录，与消息路由共用同一种哈希链，以设备凭证(未配对时为本机密钥)计算HMAC
+type AuditEntry = auditlog.Entry
+
+// AuditLog 只追加的审计日志(每行一条json)，与设备凭证一起保存在用户配置目录中
+type AuditLog = auditlog.Log
+
+func OpenAuditLog(file string) (*AuditLog, base.Result) {
+	key, res := auditKey(true)
+	if !res.IsOk() {
+		return nil, res
+	}
+	return auditlog.Open(file, key)
+}
+
+// auditKey 审计日志哈希链的密钥：已配对时为设备凭证，未配对时为本机生成、用DPAPI加密保存的密钥；
+// 配对或解除配对后密钥改变，已有的日志在下次打开时归档
+func auditKey(create bool) ([]byte, base.Result) {
+	device, res := loadDeviceCredential()
+	if !res.IsOk() {
+		return nil, res
+	}
+	if device != nil {
+		return []byte(device.Credential), base.SUCCESS
+	}
+
+	file := filepath.Join(dataDir(), auditKeyFileName)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("generate audit key failed", err)
+		}
+		if data, err = dpapiEncrypt(key); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("encrypt audit key failed", err)
+		}
+		if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("create audit directory failed", err)
+		}
+		if err = os.WriteFile(file, data, 0600); err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("write audit key failed", err)
+		}
+		return key, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read audit key failed", err)
+	}
+
+	key, err := dpapiDecrypt(data)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("decrypt audit key failed", err)
+	}
+	return key, base.SUCCESS
+}
+
+// audit 记录一条审计日志，审计日志打开失败时忽略
+func (r *HNReminder) audit(event, actor, target, result string) {
+	if r.auditLog != nil {
+		r.auditLog.Append(r.config.ClientId, event, actor, target, result)
+	}
+}
+
+// proofActor 审计日志中凭证的来源
+func proofActor(proof UnlockProof) string {
+	actor := proof.Source
+	if proof.Source == ProofTag {
+		actor += ":" + proof.Value
+	}
+	if proof.Routed {
+		actor += "@router"
+	}
+	return actor
+}
+
+func parseAuditTime(value string) (time.Time, base.Result) {
+	if value == "" {
+		return time.Time{}, base.SUCCESS
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), base.SUCCESS
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, base.INVALID_PARAM.SetMsg("invalid time: " + value)
+	}
+	return t, base.SUCCESS
+}
+
+// auditFilePath 审计日志默认保存在当前用户的配置目录中，不随临时目录清理
+func auditFilePath() string {
+	return filepath.Join(dataDir(), auditFileName)
+}
+
+// VerifyAudit 校验本机的审计日志，发现删改或截断时以非0退出
+func VerifyAudit(loadBuilding func()) {
+	file := auditFilePath()
+	key, res := auditKey(false)
+	if !res.IsOk() {
+		fmt.Printf("audit key is unavailable: %s\n", res.Message())
+		os.Exit(1)
+	}
+	count, head, res := auditlog.VerifyFile(file, key)
+	if !res.IsOk() {
+		fmt.Printf("audit log %s is NOT intact after %d entries: %s\n", file, count, res.Message())
+		os.Exit(1)
+	}
+	fmt.Printf("audit log %s is intact: %d entries, head %s\n", file, count, head)
+}
@@ -0,0 +1,643 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type _CalendarConfig struct {
+	Sources    []string `yaml:"sources" secret:"true"` // ICS日历，本地文件(相对路径基于配置文件目录)或http(s)/webcal地址(私有地址中含令牌)
+	RefreshSec int      `yaml:"refresh_sec"`           // 重新加载日历的间隔，默认15分钟
+	GraceSec   *int     `yaml:"grace_sec"`             // 会议结束后再推迟的时长，默认5分钟，可设为0
+}
+
+type _CalEvent struct {
+	summary  string
+	start    time.Time
+	duration time.Duration
+	rule     *_RRule
+	exdates  map[int64]bool
+}
+
+type _RRule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []_ByDay
+	byMonthDay []int // 负数表示倒数第几天
+}
+
+// _ByDay BYDAY中的一项，nth为月度规则中的序号(如1MO为第一个周一，-1FR为最后一个周五)，0表示每个
+type _ByDay struct {
+	nth     int
+	weekday time.Weekday
+}
+
+// errUnsupportedRRule 无法正确展开的重复规则，该事件会被跳过而不是产生错误的忙碌时段
+var errUnsupportedRRule = errors.New("unsupported rrule")
+
+// BusyInterval 日历中的忙碌时段
+type BusyInterval struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Calendar 从ICS日历中解析忙碌时段，用于在会议中推迟提醒
+type Calendar struct {
+	sources []string
+	refresh time.Duration
+	client  *http.Client
+
+	mutex     sync.Mutex
+	events    []*_CalEvent
+	busy      []BusyInterval
+	expandDay string
+}
+
+// NewCalendar 未配置日历时返回nil
+func NewCalendar(cfg *_CalendarConfig, configFile string) *Calendar {
+	if len(cfg.Sources) == 0 {
+		return nil
+	}
+
+	c := &Calendar{
+		refresh: time.Duration(cfg.RefreshSec) * time.Second,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, source := range cfg.Sources {
+		if strings.HasPrefix(source, "webcal://") {
+			source = "https://" + strings.TrimPrefix(source, "webcal://")
+		} else if !isHttpSource(source) && !filepath.IsAbs(source) {
+			source = filepath.Join(filepath.Dir(configFile), source)
+		}
+		c.sources = append(c.sources, source)
+	}
+	return c
+}
+
+func (c *Calendar) refreshLoop(running func() bool) {
+	for running() {
+		c.Reload()
+		time.Sleep(c.refresh)
+	}
+}
+
+// Reload 重新加载所有日历，加载失败的日历保留上次的结果
+func (c *Calendar) Reload() {
+	var events []*_CalEvent
+	failed := false
+	for _, source := range c.sources {
+		parsed, res := c.load(source)
+		if !res.IsOk() {
+			logger.Warnw("load calendar failed", res, "source", source)
+			failed = true
+			continue
+		}
+		events = append(events, parsed...)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if failed && len(events) == 0 {
+		return
+	}
+	c.events = events
+	c.expandDay = ""
+	logger.Debugw("calendar reloaded", "events", len(events))
+}
+
+// BusyAt 返回t时刻所处的忙碌时段，多个时段重叠时合并为最晚结束的一个
+func (c *Calendar) BusyAt(t time.Time) (BusyInterval, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expand(t)
+
+	var found BusyInterval
+	ok := false
+	for _, b := range c.busy {
+		if b.Start.After(t) {
+			break
+		}
+		if b.End.After(t) && (!ok || b.End.After(found.End)) {
+			found = b
+			ok = true
+		}
+	}
+
+	// 紧接着的会议视为同一个忙碌时段
+	for ok {
+		extended := false
+		for _, b := range c.busy {
+			if !b.Start.After(found.End) && b.End.After(found.End) {
+				found.End = b.End
+				extended = true
+			}
+		}
+		if !extended {
+			break
+		}
+	}
+	return found, ok
+}
+
+// expand 将事件(含重复规则)展开为t前后两天内的忙碌时段
+func (c *Calendar) expand(t time.Time) {
+	day := t.Format("2006-01-02")
+	if c.expandDay == day {
+		return
+	}
+
+	from := t.Add(-24 * time.Hour)
+	to := t.Add(48 * time.Hour)
+	c.busy = c.busy[:0]
+	for _, e := range c.events {
+		e.occurrences(from, to, func(start time.Time) {
+			c.busy = append(c.busy, BusyInterval{Summary: e.summary, Start: start, End: start.Add(e.duration)})
+		})
+	}
+	sort.Slice(c.busy, func(i, j int) bool {
+		return c.busy[i].Start.Before(c.busy[j].Start)
+	})
+	c.expandDay = day
+}
+
+func (c *Calendar) load(source string) ([]*_CalEvent, base.Result) {
+	var reader io.ReadCloser
+	if isHttpSource(source) {
+		rsp, err := c.client.Get(source)
+		if err != nil {
+			return nil, base.REMOTE_SYSTEM_ERROR.AppendErr("fetch calendar failed", err)
+		}
+		if rsp.StatusCode != http.StatusOK {
+			rsp.Body.Close()
+			return nil, base.REMOTE_SYSTEM_ERROR.SetMsg("fetch calendar failed: " + rsp.Status)
+		}
+		reader = rsp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, base.INTERNAL_ERROR.AppendErr("open calendar failed", err)
+		}
+		reader = file
+	}
+	defer reader.Close()
+
+	events, err := parseICS(reader)
+	if err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse calendar failed", err)
+	}
+	return events, base.SUCCESS
+}
+
+func isHttpSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// occurrences 回调[from, to)内与之相交的每次发生。没有COUNT时从from-duration所在的周期开始展开，
+// 有COUNT时需从DTSTART开始计数；展开的周期数以to为上限，不会因DTSTART久远而漏掉近期的发生
+func (e *_CalEvent) occurrences(from, to time.Time, fn func(start time.Time)) {
+	emit := func(start time.Time) {
+		if start.Before(to) && start.Add(e.duration).After(from) && !e.exdates[start.Unix()] {
+			fn(start)
+		}
+	}
+
+	if e.rule == nil {
+		emit(e.start)
+		return
+	}
+
+	r := e.rule
+	first, last := 0, r.periodsBetween(e.start, to)/r.interval+1
+	if r.count == 0 {
+		// 往前多留一个周期，BYDAY可能落在周期起点之前的几天
+		if first = r.periodsBetween(e.start, from.Add(-e.duration))/r.interval - 1; first < 0 {
+			first = 0
+		}
+	}
+	count := 0
+	for i := first; i <= last; i++ {
+		var candidates []time.Time
+		switch r.freq {
+		case "DAILY":
+			candidates = []time.Time{e.start.AddDate(0, 0, i*r.interval)}
+		case "WEEKLY":
+			weekStart := e.start.AddDate(0, 0, i*7*r.interval)
+			if len(r.byDay) == 0 {
+				candidates = []time.Time{weekStart}
+			} else {
+				// 以DTSTART所在周的周一为基准计算BYDAY
+				monday := weekStart.AddDate(0, 0, -((int(weekStart.Weekday()) + 6) % 7))
+				for _, d := range r.byDay {
+					candidates = append(candidates, monday.AddDate(0, 0, (int(d.weekday)+6)%7))
+				}
+				sort.Slice(candidates, func(a, b int) bool { return candidates[a].Before(candidates[b]) })
+			}
+		case "MONTHLY":
+			candidates = r.monthlyCandidates(e.start, i*r.interval)
+		case "YEARLY":
+			// 不用AddDate，避免2月29日滚动到3月1日，不存在的日期按RFC 5545跳过
+			if start := dateIn(e.start, e.start.Year()+i*r.interval, e.start.Month(), e.start.Day()); !start.IsZero() {
+				candidates = []time.Time{start}
+			}
+		default:
+			emit(e.start)
+			return
+		}
+
+		for _, start := range candidates {
+			if start.Before(e.start) {
+				continue
+			}
+			if !r.until.IsZero() && start.After(r.until) {
+				return
+			}
+			if r.count > 0 && count >= r.count {
+				return
+			}
+			count++
+			if !start.Before(to) {
+				return
+			}
+			emit(start)
+		}
+	}
+}
+
+// periodsBetween 从dtstart到t经过的完整周期数(按FREQ，不考虑INTERVAL)，t在dtstart之前时为0
+func (r *_RRule) periodsBetween(dtstart, t time.Time) int {
+	if !t.After(dtstart) {
+		return 0
+	}
+	t = t.In(dtstart.Location())
+	days := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).
+		Sub(time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	switch r.freq {
+	case "DAILY":
+		return days
+	case "WEEKLY":
+		return days / 7
+	case "MONTHLY":
+		return (t.Year()-dtstart.Year())*12 + int(t.Month()-dtstart.Month())
+	case "YEARLY":
+		return t.Year() - dtstart.Year()
+	}
+	return 0
+}
+
+// monthlyCandidates 返回DTSTART之后第offset个月中的各次发生，按BYMONTHDAY、BYDAY或DTSTART的日期计算，不存在的日期跳过
+func (r *_RRule) monthlyCandidates(dtstart time.Time, offset int) []time.Time {
+	first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(offset), 1, 0, 0, 0, 0, dtstart.Location())
+	year, month := first.Year(), first.Month()
+	days := daysIn(year, month)
+
+	var candidates []time.Time
+	add := func(day int) {
+		if start := dateIn(dtstart, year, month, day); !start.IsZero() {
+			candidates = append(candidates, start)
+		}
+	}
+
+	switch {
+	case len(r.byMonthDay) > 0:
+		for _, day := range r.byMonthDay {
+			if day < 0 {
+				day = days + 1 + day
+			}
+			add(day)
+		}
+	case len(r.byDay) > 0:
+		for _, d := range r.byDay {
+			// 当月第一个该星期几的日期
+			firstDay := 1 + (int(d.weekday)-int(first.Weekday())+7)%7
+			switch {
+			case d.nth > 0:
+				add(firstDay + (d.nth-1)*7)
+			case d.nth < 0:
+				lastDay := firstDay + (days-firstDay)/7*7
+				add(lastDay + (d.nth+1)*7)
+			default:
+				for day := firstDay; day <= days; day += 7 {
+					add(day)
+				}
+			}
+		}
+	default:
+		add(dtstart.Day())
+	}
+
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].Before(candidates[b]) })
+	return candidates
+}
+
+// dateIn 返回year-month-day与dtstart同一时刻的时间，日期不存在时返回零值
+func dateIn(dtstart time.Time, year int, month time.Month, day int) time.Time {
+	if day < 1 || day > daysIn(year, month) {
+		return time.Time{}
+	}
+	return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// parseICS 解析VEVENT中的SUMMARY/DTSTART/DTEND/DURATION/RRULE/EXDATE，忽略全天、已取消和空闲(TRANSPARENT)事件
+func parseICS(reader io.Reader) ([]*_CalEvent, error) {
+	lines, err := unfoldICS(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*_CalEvent
+	var cur *_CalEvent
+	var end time.Time
+	skip := false
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			cur = &_CalEvent{exdates: map[int64]bool{}}
+			end = time.Time{}
+			skip = false
+		case name == "END" && value == "VEVENT":
+			if cur != nil && !skip && !cur.start.IsZero() {
+				if cur.duration == 0 && !end.IsZero() {
+					cur.duration = end.Sub(cur.start)
+				}
+				if cur.duration > 0 {
+					events = append(events, cur)
+				}
+			}
+			cur = nil
+		case cur == nil:
+			continue
+		case name == "SUMMARY":
+			cur.summary = unescapeICS(value)
+		case name == "DTSTART":
+			if params["VALUE"] == "DATE" {
+				skip = true
+				continue
+			}
+			if cur.start, err = parseICSTime(value, params["TZID"]); err != nil {
+				return nil, err
+			}
+		case name == "DTEND":
+			if end, err = parseICSTime(value, params["TZID"]); err != nil {
+				return nil, err
+			}
+		case name == "DURATION":
+			if cur.duration, err = parseICSDuration(value); err != nil {
+				return nil, err
+			}
+		case name == "RRULE":
+			if cur.rule, err = parseRRule(value); errors.Is(err, errUnsupportedRRule) {
+				logger.Warnw("skip calendar event with unsupported rrule", err, "summary", cur.summary)
+				skip = true
+			} else if err != nil {
+				return nil, err
+			}
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				if t, err := parseICSTime(v, params["TZID"]); err == nil {
+					cur.exdates[t.Unix()] = true
+				}
+			}
+		case name == "STATUS" && value == "CANCELLED":
+			skip = true
+		case name == "TRANSP" && value == "TRANSPARENT":
+			skip = true
+		}
+	}
+	return events, nil
+}
+
+func unfoldICS(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func splitICSLine(line string) (name string, params map[string]string, value string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return line, nil, ""
+	}
+
+	head := strings.Split(line[:colon], ";")
+	params = map[string]string{}
+	for _, p := range head[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], "\"")
+		}
+	}
+	return strings.ToUpper(head[0]), params, line[colon+1:]
+}
+
+func parseICSTime(value, tzid string) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+
+	loc := time.Local
+	if tzid != "" {
+		// Outlook等会使用Windows时区名，无法识别时按本地时间处理
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	if len(value) == 8 {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseICSDuration 解析如PT30M、PT1H30M、P1D的时长
+func parseICSDuration(value string) (time.Duration, error) {
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+	var d time.Duration
+	num := ""
+	for _, ch := range value {
+		switch {
+		case ch >= '0' && ch <= '9':
+			num += string(ch)
+		case ch == 'T':
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration: %s", value)
+			}
+			switch ch {
+			case 'W':
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case 'D':
+				d += time.Duration(n) * 24 * time.Hour
+			case 'H':
+				d += time.Duration(n) * time.Hour
+			case 'M':
+				d += time.Duration(n) * time.Minute
+			case 'S':
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("invalid duration: %s", value)
+			}
+			num = ""
+		}
+	}
+	return d, nil
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRule 支持DAILY/WEEKLY(BYDAY)/MONTHLY(BYDAY含序号、BYMONTHDAY)/YEARLY(仅DTSTART的日期)，
+// 以及INTERVAL/COUNT/UNTIL，其他规则返回errUnsupportedRRule
+func parseRRule(value string) (*_RRule, error) {
+	r := &_RRule{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		var err error
+		switch key := strings.ToUpper(kv[0]); key {
+		case "FREQ":
+			r.freq = strings.ToUpper(kv[1])
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(kv[1]); err != nil || r.interval <= 0 {
+				return nil, fmt.Errorf("invalid rrule interval: %s", value)
+			}
+		case "COUNT":
+			if r.count, err = strconv.Atoi(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid rrule count: %s", value)
+			}
+		case "UNTIL":
+			if r.until, err = parseICSTime(kv[1], ""); err != nil {
+				return nil, fmt.Errorf("invalid rrule until: %s", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				name := strings.TrimLeft(day, "+-0123456789")
+				wd, ok := icsWeekdays[strings.ToUpper(name)]
+				if !ok {
+					return nil, fmt.Errorf("invalid rrule byday: %s", value)
+				}
+				nth := 0
+				if prefix := day[:len(day)-len(name)]; prefix != "" {
+					if nth, err = strconv.Atoi(prefix); err != nil || nth == 0 || nth < -5 || nth > 5 {
+						return nil, fmt.Errorf("invalid rrule byday: %s", value)
+					}
+				}
+				r.byDay = append(r.byDay, _ByDay{nth: nth, weekday: wd})
+			}
+		case "BYMONTHDAY":
+			for _, str := range strings.Split(kv[1], ",") {
+				day, err := strconv.Atoi(str)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return nil, fmt.Errorf("invalid rrule bymonthday: %s", value)
+				}
+				r.byMonthDay = append(r.byMonthDay, day)
+			}
+		case "WKST":
+		default:
+			// BYMONTH、BYSETPOS、BYHOUR等
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRRule, key)
+		}
+	}
+
+	switch r.freq {
+	case "DAILY":
+		if len(r.byDay) > 0 || len(r.byMonthDay) > 0 {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRRule, value)
+		}
+	case "WEEKLY":
+		if len(r.byMonthDay) > 0 {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRRule, value)
+		}
+	case "MONTHLY":
+		if len(r.byDay) > 0 && len(r.byMonthDay) > 0 {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRRule, value)
+		}
+	case "YEARLY":
+		if len(r.byDay) > 0 || len(r.byMonthDay) > 0 {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRRule, value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: FREQ=%s", errUnsupportedRRule, r.freq)
+	}
+	return r, nil
+}
+
+func unescapeICS(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// checkCalendar 会议中到了休息时间则推迟到会议结束后，调用方需持有锁
+func (r *HNReminder) checkCalendar(now time.Time) (deferred bool) {
+	if r.calendar == nil {
+		return false
+	}
+
+	if !r.deferredUntil.IsZero() && now.Before(r.deferredUntil) {
+		return true
+	}
+
+	busy, ok := r.calendar.BusyAt(now)
+	if !ok {
+		if !r.deferredUntil.IsZero() {
+			logger.Infow("HydrateNow: deferral ended", "event", r.deferredBy)
+			r.deferredUntil = time.Time{}
+			r.deferredBy = ""
+			// 推迟期间不计入超时，避免会议结束后直接升级到最高级别
+			for _, rem := range r.reminders {
+				rem.remindSince = now
+			}
+		}
+		return false
+	}
+
+	r.deferredUntil = busy.End.Add(time.Duration(*r.config.Calendar.GraceSec) * time.Second)
+	r.deferredBy = busy.Summary
+	logger.Infow("HydrateNow: deferred by calendar", "event", busy.Summary, "until", r.deferredUntil)
+	r.closeReminder()
+	return true
+}
+
+// GetDeferred 返回因日历推迟提醒的截止时间和会议名称
+func (r *HNReminder) GetDeferred() (until time.Time, event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.deferredUntil.IsZero() || time.Now().After(r.deferredUntil) {
+		return time.Time{}, ""
+	}
+	return r.deferredUntil, r.deferredBy
+}
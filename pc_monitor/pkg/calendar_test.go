@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICS = `BEGIN:VCALENDAR
+BEGIN:VEVENT
+SUMMARY:Weekly sync\, team
+DTSTART;TZID=Asia/Shanghai:20240506T100000
+DTEND;TZID=Asia/Shanghai:20240506T110000
+RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4
+EXDATE;TZID=Asia/Shanghai:20240508T100000
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Long descrip
+ tion folded
+DTSTART:20240506T020000Z
+DURATION:PT1H30M
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Holiday
+DTSTART;VALUE=DATE:20240501
+DTEND;VALUE=DATE:20240502
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Cancelled
+DTSTART:20240506T020000Z
+DURATION:PT1H
+STATUS:CANCELLED
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Free time
+DTSTART:20240506T020000Z
+DURATION:PT1H
+TRANSP:TRANSPARENT
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Unsupported
+DTSTART:20240506T020000Z
+DURATION:PT1H
+RRULE:FREQ=MONTHLY;BYDAY=MO;BYSETPOS=-1
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParseICS(t *testing.T) {
+	events, err := parseICS(strings.NewReader(strings.ReplaceAll(testICS, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	// 全天、已取消、空闲及无法展开的事件被忽略
+	if len(events) != 2 {
+		t.Fatalf("parsed %d events, want 2", len(events))
+	}
+
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	sync := events[0]
+	if sync.summary != "Weekly sync, team" || !sync.start.Equal(time.Date(2024, 5, 6, 10, 0, 0, 0, shanghai)) || sync.duration != time.Hour {
+		t.Errorf("weekly event = %q %s %s", sync.summary, sync.start, sync.duration)
+	}
+	if sync.rule == nil || sync.rule.freq != "WEEKLY" || sync.rule.count != 4 || len(sync.rule.byDay) != 2 {
+		t.Errorf("weekly rule = %+v", sync.rule)
+	}
+	if !sync.exdates[time.Date(2024, 5, 8, 10, 0, 0, 0, shanghai).Unix()] {
+		t.Errorf("exdate not parsed: %v", sync.exdates)
+	}
+
+	folded := events[1]
+	if folded.summary != "Long description folded" || folded.duration != 90*time.Minute {
+		t.Errorf("folded event = %q %s", folded.summary, folded.duration)
+	}
+}
+
+func TestParseICSInvalid(t *testing.T) {
+	cases := []string{
+		"BEGIN:VEVENT\nDTSTART:2024-05-06\nEND:VEVENT",
+		"BEGIN:VEVENT\nDTSTART:20240506T020000Z\nDURATION:PTXM\nEND:VEVENT",
+		"BEGIN:VEVENT\nDTSTART:20240506T020000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;INTERVAL=0\nEND:VEVENT",
+		"BEGIN:VEVENT\nDTSTART:20240506T020000Z\nDURATION:PT1H\nRRULE:FREQ=MONTHLY;BYDAY=9MO\nEND:VEVENT",
+	}
+	for _, ics := range cases {
+		if _, err := parseICS(strings.NewReader(ics)); err == nil {
+			t.Errorf("parseICS(%q) succeeded, want error", ics)
+		}
+	}
+}
+
+func TestParseRRuleUnsupported(t *testing.T) {
+	cases := []string{
+		"FREQ=HOURLY",
+		"FREQ=MONTHLY;BYSETPOS=1;BYDAY=MO",
+		"FREQ=YEARLY;BYMONTH=3",
+		"FREQ=YEARLY;BYDAY=1MO",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=MONTHLY;BYDAY=MO;BYMONTHDAY=13",
+	}
+	for _, rule := range cases {
+		if _, err := parseRRule(rule); err == nil || !strings.Contains(err.Error(), errUnsupportedRRule.Error()) {
+			t.Errorf("parseRRule(%q) err = %v, want unsupported", rule, err)
+		}
+	}
+}
+
+func TestCalEventOccurrences(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		name    string
+		start   time.Time
+		rule    string
+		exdates []time.Time
+		want    []time.Time
+	}{
+		{"single", at(2024, 5, 6), "", nil, []time.Time{at(2024, 5, 6)}},
+		{"daily count", at(2024, 5, 6), "FREQ=DAILY;COUNT=3", nil,
+			[]time.Time{at(2024, 5, 6), at(2024, 5, 7), at(2024, 5, 8)}},
+		{"daily interval until", at(2024, 5, 6), "FREQ=DAILY;INTERVAL=2;UNTIL=20240510T090000Z", nil,
+			[]time.Time{at(2024, 5, 6), at(2024, 5, 8), at(2024, 5, 10)}},
+		// 被排除的日期仍计入COUNT
+		{"exdate", at(2024, 5, 6), "FREQ=DAILY;COUNT=3", []time.Time{at(2024, 5, 7)},
+			[]time.Time{at(2024, 5, 6), at(2024, 5, 8)}},
+		{"weekly byday", at(2024, 5, 6), "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4", nil,
+			[]time.Time{at(2024, 5, 6), at(2024, 5, 8), at(2024, 5, 10), at(2024, 5, 13)}},
+		// DTSTART之前的BYDAY不算
+		{"weekly byday before dtstart", at(2024, 5, 8), "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=3", nil,
+			[]time.Time{at(2024, 5, 8), at(2024, 5, 13), at(2024, 5, 15)}},
+		{"biweekly until", at(2024, 5, 6), "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;UNTIL=20240604T000000Z", nil,
+			[]time.Time{at(2024, 5, 7), at(2024, 5, 21)}},
+		{"monthly first monday", at(2024, 5, 6), "FREQ=MONTHLY;BYDAY=1MO;COUNT=3", nil,
+			[]time.Time{at(2024, 5, 6), at(2024, 6, 3), at(2024, 7, 1)}},
+		{"monthly last friday", at(2024, 5, 31), "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", nil,
+			[]time.Time{at(2024, 5, 31), at(2024, 6, 28), at(2024, 7, 26)}},
+		{"monthly every tuesday", at(2024, 5, 7), "FREQ=MONTHLY;BYDAY=TU;COUNT=5", nil,
+			[]time.Time{at(2024, 5, 7), at(2024, 5, 14), at(2024, 5, 21), at(2024, 5, 28), at(2024, 6, 4)}},
+		// 没有31日的月份跳过，不会滚动到下个月
+		{"monthly on 31st", at(2024, 1, 31), "FREQ=MONTHLY;COUNT=3", nil,
+			[]time.Time{at(2024, 1, 31), at(2024, 3, 31), at(2024, 5, 31)}},
+		{"monthly last day", at(2024, 1, 31), "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", nil,
+			[]time.Time{at(2024, 1, 31), at(2024, 2, 29), at(2024, 3, 31)}},
+		{"monthly interval", at(2024, 1, 15), "FREQ=MONTHLY;INTERVAL=5;BYMONTHDAY=15;COUNT=3", nil,
+			[]time.Time{at(2024, 1, 15), at(2024, 6, 15), at(2024, 11, 15)}},
+		{"yearly leap day", at(2024, 2, 29), "FREQ=YEARLY;COUNT=2", nil,
+			[]time.Time{at(2024, 2, 29), at(2028, 2, 29)}},
+	}
+	for _, c := range cases {
+		e := &_CalEvent{start: c.start, duration: 30 * time.Minute, exdates: map[int64]bool{}}
+		if c.rule != "" {
+			rule, err := parseRRule(c.rule)
+			if err != nil {
+				t.Errorf("%s: parseRRule: %v", c.name, err)
+				continue
+			}
+			e.rule = rule
+		}
+		for _, ex := range c.exdates {
+			e.exdates[ex.Unix()] = true
+		}
+
+		var got []time.Time
+		e.occurrences(at(2024, 1, 1), at(2030, 1, 1), func(start time.Time) { got = append(got, start) })
+		if !equalTimes(got, c.want) {
+			t.Errorf("%s: occurrences = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCalEventOccurrencesWindow(t *testing.T) {
+	start := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	rule, _ := parseRRule("FREQ=DAILY")
+	e := &_CalEvent{start: start, duration: time.Hour, rule: rule, exdates: map[int64]bool{}}
+
+	// 开始于窗口之前但仍在进行中的也算
+	var got []time.Time
+	e.occurrences(start.AddDate(0, 0, 2).Add(30*time.Minute), start.AddDate(0, 0, 4), func(s time.Time) { got = append(got, s) })
+	want := []time.Time{start.AddDate(0, 0, 2), start.AddDate(0, 0, 3)}
+	if !equalTimes(got, want) {
+		t.Errorf("occurrences = %v, want %v", got, want)
+	}
+}
+
+// DTSTART久远的规则也能展开到近期，COUNT仍从DTSTART计数
+func TestCalEventOccurrencesFarFromStart(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		name  string
+		start time.Time
+		rule  string
+		want  []time.Time
+	}{
+		{"daily since 1990", at(1990, 1, 1), "FREQ=DAILY", []time.Time{at(2024, 5, 6), at(2024, 5, 7)}},
+		{"weekly byday since 1990", at(1990, 1, 1), "FREQ=WEEKLY;BYDAY=MO,TU", []time.Time{at(2024, 5, 6), at(2024, 5, 7)}},
+		{"every 3 days", at(1990, 1, 3), "FREQ=DAILY;INTERVAL=3", []time.Time{at(2024, 5, 7)}},
+		{"monthly since 1990", at(1990, 1, 7), "FREQ=MONTHLY", []time.Time{at(2024, 5, 7)}},
+		{"yearly since 1900", at(1900, 5, 6), "FREQ=YEARLY", []time.Time{at(2024, 5, 6)}},
+		{"count ended long ago", at(1990, 1, 1), "FREQ=DAILY;COUNT=10000", nil},
+		{"count still running", at(1990, 1, 1), "FREQ=DAILY;COUNT=20000", []time.Time{at(2024, 5, 6), at(2024, 5, 7)}},
+		{"until", at(1990, 1, 1), "FREQ=DAILY;UNTIL=20240506T235959Z", []time.Time{at(2024, 5, 6)}},
+	}
+	for _, c := range cases {
+		rule, err := parseRRule(c.rule)
+		if err != nil {
+			t.Fatalf("%s: parseRRule: %v", c.name, err)
+		}
+		e := &_CalEvent{start: c.start, duration: 30 * time.Minute, rule: rule, exdates: map[int64]bool{}}
+
+		var got []time.Time
+		e.occurrences(at(2024, 5, 6), at(2024, 5, 8), func(start time.Time) { got = append(got, start) })
+		if !equalTimes(got, c.want) {
+			t.Errorf("%s: occurrences = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCalendarBusyAt(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 5, 6, hour, minute, 0, 0, time.UTC) }
+	event := func(summary string, start time.Time, duration time.Duration) *_CalEvent {
+		return &_CalEvent{summary: summary, start: start, duration: duration, exdates: map[int64]bool{}}
+	}
+	c := &Calendar{events: []*_CalEvent{
+		event("standup", at(9, 0), 15*time.Minute),
+		event("review", at(10, 0), time.Hour),
+		event("1:1", at(11, 0), 30*time.Minute),      // 紧接着review
+		event("overlap", at(10, 15), 30*time.Minute), // 在review之内
+		event("planning", at(14, 0), 2*time.Hour),    // 与下一个重叠
+		event("interview", at(15, 30), 1*time.Hour),  // 比planning结束得晚
+		event("lunch", at(12, 30), 30*time.Minute),   // 与1:1之间有空隙
+	}}
+
+	cases := []struct {
+		t       time.Time
+		ok      bool
+		summary string
+		end     time.Time
+	}{
+		{at(8, 59), false, "", time.Time{}},
+		{at(9, 0), true, "standup", at(9, 15)},
+		{at(9, 15), false, "", time.Time{}},
+		{at(10, 20), true, "review", at(11, 30)},
+		{at(11, 10), true, "1:1", at(11, 30)},
+		{at(12, 0), false, "", time.Time{}},
+		{at(14, 30), true, "planning", at(16, 30)},
+		{at(16, 0), true, "interview", at(16, 30)},
+	}
+	for _, ca := range cases {
+		busy, ok := c.BusyAt(ca.t)
+		if ok != ca.ok || (ok && (busy.Summary != ca.summary || !busy.End.Equal(ca.end))) {
+			t.Errorf("BusyAt(%s) = %q until %s, %v; want %q until %s, %v", ca.t.Format("15:04"), busy.Summary, busy.End.Format("15:04"), ok, ca.summary, ca.end.Format("15:04"), ca.ok)
+		}
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCalendarGraceSecDefault(t *testing.T) {
+	var config _Config
+	config.fillDefaults()
+	if config.Calendar.GraceSec == nil || *config.Calendar.GraceSec != 300 {
+		t.Errorf("default grace_sec = %v, want 300", config.Calendar.GraceSec)
+	}
+
+	// 显式配置的0应保留
+	zero := 0
+	config = _Config{}
+	config.Calendar.GraceSec = &zero
+	config.fillDefaults()
+	if *config.Calendar.GraceSec != 0 {
+		t.Errorf("explicit grace_sec 0 became %d", *config.Calendar.GraceSec)
+	}
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 以下命令通过本地接口(/api/v1)操作正在运行的监测程序，--json时原样输出返回的data
+
+// CliInfo hydrate_pc.exe info [--json]，status仍是查询Windows服务的状态
+func CliInfo(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("info")
+	_ = flags.Parse(gOptions.Args)
+
+	var status ApiStatus
+	if !callLocalApi(http.MethodGet, "/api/v1/status", nil, &status) || printJson(*asJson, status) {
+		return
+	}
+
+	now := time.Now()
+	fmt.Println("client:", status.ClientId)
+	switch {
+	case status.Pause.Paused:
+		fmt.Println("paused until", status.Pause.Until.Format("15:04"))
+	case status.OffDuty && !status.NextStart.IsZero():
+		fmt.Println("off duty, timer starts at", status.NextStart.Format("01-02 15:04"))
+	case status.OffDuty:
+		fmt.Println("off duty")
+	case status.DeferredUntil.After(now):
+		fmt.Printf("deferred until %s by %s\n", status.DeferredUntil.Format("15:04"), status.DeferredBy)
+	}
+	for _, rem := range status.Reminders {
+		state := "next break in " + shortDuration(time.Duration(rem.NextSec)*time.Second)
+		if rem.ShouldRemind {
+			state = "overdue " + shortDuration(time.Duration(rem.OverdueSec)*time.Second) + ", unlock by " + rem.Unlock
+			if rem.Progress != "" {
+				state += " (" + rem.Progress + ")"
+			}
+		}
+		fmt.Printf("  %s: %s, %d breaks today\n", rem.Name, state, rem.BreaksToday)
+	}
+	fmt.Printf("snoozes left: %d, pauses left: %d", status.Snooze.Remaining, status.Pause.Remaining)
+	if status.Guardian.Enabled {
+		fmt.Printf(", guardian codes left: %d", status.Guardian.Remaining)
+		if !status.Guardian.LockedUntil.IsZero() {
+			fmt.Print(" (locked until ", status.Guardian.LockedUntil.Format("15:04"), ")")
+		}
+	}
+	fmt.Println()
+}
+
+// CliSnooze hydrate_pc.exe snooze [--min 10] [--name water] [--json]
+func CliSnooze(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("snooze")
+	minutes := flags.Int("min", 0, "snooze minutes, one of snooze.durations_min (default the first one)")
+	name := flags.String("name", "", "reminder to snooze (default all due reminders)")
+	_ = flags.Parse(gOptions.Args)
+
+	query := url.Values{"name": {*name}}
+	if *minutes > 0 {
+		query.Set("min", strconv.Itoa(*minutes))
+	}
+	var result struct {
+		Until     time.Time `json:"until"`
+		Remaining int       `json:"remaining"`
+		Reminders []string  `json:"reminders"`
+	}
+	if !callLocalApi(http.MethodPost, "/api/v1/snooze", query, &result) || printJson(*asJson, result) {
+		return
+	}
+	fmt.Printf("snoozed %s until %s, %d snoozes left today\n", strings.Join(result.Reminders, ", "), result.Until.Format("15:04"), result.Remaining)
+}
+
+// CliPause hydrate_pc.exe pause --min 60 [--json]
+func CliPause(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("pause")
+	minutes := flags.Int("min", 0, "pause minutes, up to pause.max_min")
+	_ = flags.Parse(gOptions.Args)
+	if *minutes <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var result struct {
+		Until     time.Time `json:"until"`
+		Remaining int       `json:"remaining"`
+	}
+	if !callLocalApi(http.MethodPost, "/api/v1/pause", url.Values{"min": {strconv.Itoa(*minutes)}}, &result) || printJson(*asJson, result) {
+		return
+	}
+	fmt.Printf("paused until %s, %d pauses left today\n", result.Until.Format("15:04"), result.Remaining)
+}
+
+// CliResume hydrate_pc.exe resume
+func CliResume(loadBuilding func()) {
+	flags, _ := newCliFlagSet("resume")
+	_ = flags.Parse(gOptions.Args)
+
+	if callLocalApi(http.MethodPost, "/api/v1/resume", nil, nil) {
+		fmt.Println("resumed")
+	}
+}
+
+// CliHistory hydrate_pc.exe history [--since 24h] [--event unlock] [--json]
+func CliHistory(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("history")
+	since := flags.Duration("since", 24*time.Hour, "how far back to list")
+	event := flags.String("event", "", "only list events with this prefix, e.g. unlock, snooze, tamper")
+	_ = flags.Parse(gOptions.Args)
+
+	query := url.Values{
+		"from":  {strconv.FormatInt(time.Now().Add(-*since).Unix(), 10)},
+		"event": {*event},
+	}
+	var entries []AuditEntry
+	if !callLocalApi(http.MethodGet, "/api/v1/history", query, &entries) || printJson(*asJson, entries) {
+		return
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s  %-16s %-20s %s %s\n", entry.Time.Local().Format("01-02 15:04:05"), entry.Event, entry.Actor, entry.Target, entry.Result)
+	}
+}
+
+// CliScan hydrate_pc.exe scan --tag kitchen-1a2b3c [--sig xx] | --code xx [--name water] [--json]，模拟扫描以便测试
+func CliScan(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("scan")
+	tag := flags.String("tag", "", "tag id to scan")
+	sig := flags.String("sig", "", "tag signature (required once tags are registered)")
+	code := flags.String("code", "", "QR code to scan instead of a tag")
+	name := flags.String("name", "", "reminder to unlock (default all that accept the proof)")
+	_ = flags.Parse(gOptions.Args)
+	if *tag == "" && *code == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	query := url.Values{"tag": {*tag}, "sig": {*sig}, "name": {*name}}
+	if *code != "" {
+		query = url.Values{"code": {*code}, "name": {*name}}
+	}
+	var result struct {
+		Reminders []string `json:"reminders"`
+		Partial   []string `json:"partial"`
+		Progress  string   `json:"progress"`
+	}
+	if !callLocalApi(http.MethodPost, "/api/v1/scan", query, &result) || printJson(*asJson, result) {
+		return
+	}
+	if len(result.Reminders) > 0 {
+		fmt.Println("unlocked:", strings.Join(result.Reminders, ", "))
+	}
+	if len(result.Partial) > 0 {
+		fmt.Printf("in progress: %s (%s)\n", strings.Join(result.Partial, ", "), result.Progress)
+	}
+}
+
+func newCliFlagSet(name string) (*flag.FlagSet, *bool) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	asJson := flags.Bool("json", false, "print the raw JSON data for scripting")
+	return flags, asJson
+}
+
+// callLocalApi 调用正在运行的监测程序的本地接口，失败时打印原因并以非0退出码结束
+func callLocalApi(method, path string, query url.Values, data any) bool {
+	var config _Config
+	if res := loadConfig(ConfigFilePath(), &config); !res.IsOk() {
+		exitWith(res.AppendMsg("load config failed"))
+	}
+	config.fillDefaults()
+
+	api := fmt.Sprintf("http://127.0.0.1:%s%s", config.ApiPort, path)
+	if len(query) > 0 {
+		api += "?" + query.Encode()
+	}
+	if res := callRouter(nil, method, api, nil, data); !res.IsOk() {
+		exitWith(res)
+	}
+	return true
+}
+
+// printJson --json时输出data并返回true
+func printJson(asJson bool, data any) bool {
+	if !asJson {
+		return false
+	}
+	out, _ := json.MarshalIndent(data, "", "  ")
+	fmt.Println(string(out))
+	return true
+}
+
+func exitWith(res base.Result) {
+	fmt.Fprintln(os.Stderr, res.Message())
+	os.Exit(1)
+}
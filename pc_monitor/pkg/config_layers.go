@@ -0,0 +1,266 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 配置按以下顺序逐层覆盖，后面的覆盖前面的：
+//  1. 系统配置：程序目录下的config.yaml(或--config指定的文件)，由安装/部署脚本维护，必须存在
+//  2. 用户配置：当前用户配置目录(或--data-dir)下的config.yaml，可选
+//  3. 环境变量：HN_开头，去掉前缀后转小写，双下划线表示下一级，如HN_PAUSE__MAX_MIN=30对应pause.max_min
+//  4. 命令行参数：--log-level及--set key.path=value
+// 映射逐个键合并，列表整体替换。文件中的相对路径(内容、日历、CA证书)仍相对于系统配置所在目录
+
+const (
+	envPrefix     = "HN_"
+	sourceDefault = "default"
+)
+
+// _ConfigLayers 合并后的配置及每个值的来源
+type _ConfigLayers struct {
+	merged  map[string]any
+	sources map[string]string // 点分路径 -> 来源，如"user C:\...\config.yaml"、"env HN_API_PORT"
+	files   []string          // 实际读取的配置文件
+}
+
+// userConfigFilePath 用户配置文件
+func userConfigFilePath() string {
+	return filepath.Join(dataDir(), ConfigFileName)
+}
+
+// loadConfig 读取并合并各层配置到out，不填充默认值
+func loadConfig(configFile string, out any) base.Result {
+	layers, res := loadConfigLayers(configFile)
+	if !res.IsOk() {
+		return res
+	}
+	return layers.decode(out)
+}
+
+func loadConfigLayers(configFile string) (*_ConfigLayers, base.Result) {
+	l := &_ConfigLayers{merged: map[string]any{}, sources: map[string]string{}}
+
+	if res := l.mergeFile(configFile, "system", true); !res.IsOk() {
+		return nil, res
+	}
+	if userFile := userConfigFilePath(); userFile != configFile {
+		if res := l.mergeFile(userFile, "user", false); !res.IsOk() {
+			return nil, res
+		}
+	}
+
+	var envs []string
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envPrefix) {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		name, value, _ := strings.Cut(env, "=")
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, envPrefix)), "__")
+		l.set(path, parseConfigValue(value), "env "+name)
+	}
+
+	if gOptions.LogLevel != "" {
+		l.set([]string{"logging", "level"}, gOptions.LogLevel, "flag --log-level")
+	}
+	for _, set := range gOptions.Sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok || key == "" {
+			return nil, base.INVALID_PARAM.SetMsg("invalid --set, expect key.path=value: " + set)
+		}
+		l.set(strings.Split(key, "."), parseConfigValue(value), "flag --set "+key)
+	}
+	return l, base.SUCCESS
+}
+
+// mergeFile 合并一个配置文件，required为false时文件不存在则跳过
+func (l *_ConfigLayers) mergeFile(file, layer string, required bool) base.Result {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) && !required {
+		return base.SUCCESS
+	} else if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("read config "+file+" failed", err)
+	}
+
+	values := map[string]any{}
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return base.INVALID_PARAM.AppendErr("parse config "+file+" failed", err)
+	}
+	l.files = append(l.files, file)
+	l.mergeMap(nil, values, layer+" "+file)
+	return base.SUCCESS
+}
+
+func (l *_ConfigLayers) mergeMap(prefix []string, values map[string]any, source string) {
+	for key, value := range values {
+		path := append(append([]string{}, prefix...), key)
+		if m, ok := value.(map[string]any); ok {
+			l.mergeMap(path, m, source)
+			continue
+		}
+		l.set(path, value, source)
+	}
+}
+
+// set 设置一个值，途经的非映射值会被替换为映射
+func (l *_ConfigLayers) set(path []string, value any, source string) {
+	m := l.merged
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+
+	// 下级的来源被整体覆盖
+	dotted := strings.Join(path, ".")
+	for key := range l.sources {
+		if strings.HasPrefix(key, dotted+".") {
+			delete(l.sources, key)
+		}
+	}
+	l.sources[dotted] = source
+}
+
+// source 返回路径上最近一级的来源，未被任何一层设置时为默认值
+func (l *_ConfigLayers) source(path string) string {
+	for {
+		if source, ok := l.sources[path]; ok {
+			return source
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return sourceDefault
+		}
+		path = path[:i]
+	}
+}
+
+func (l *_ConfigLayers) decode(out any) base.Result {
+	data, err := yaml.Marshal(l.merged)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal config failed", err)
+	}
+	if err = yaml.Unmarshal(data, out); err != nil {
+		return base.INVALID_PARAM.AppendErr("invalid config", err)
+	}
+	return base.SUCCESS
+}
+
+// parseConfigValue 环境变量和--set的值按yaml解析，以便设置数字、布尔值和列表，解析失败时作为字符串
+func parseConfigValue(value string) any {
+	var v any
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil || v == nil {
+		return value
+	}
+	if _, ok := v.(map[string]any); ok {
+		return value
+	}
+	return v
+}
+
+// ConfigShow hydrate_pc.exe config show [--effective] [--json]
+// 不带--effective时输出各层合并后的配置，带--effective时输出填充默认值后实际生效的配置及每个值的来源
+func ConfigShow(loadBuilding func()) {
+	flags, asJson := newCliFlagSet("config show")
+	effective := flags.Bool("effective", false, "fill in defaults and print the source of each value")
+	args := gOptions.Args
+	if len(args) > 0 && args[0] == "show" {
+		args = args[1:]
+	} else {
+		fmt.Fprintln(os.Stderr, "usage: config show [--effective] [--json]")
+		os.Exit(2)
+	}
+	_ = flags.Parse(args)
+
+	layers, res := loadConfigLayers(ConfigFilePath())
+	if !res.IsOk() {
+		exitWith(res)
+	}
+
+	if !*effective {
+		merged := redactConfig(layers.merged)
+		if printJson(*asJson, merged) {
+			return
+		}
+		for _, file := range layers.files {
+			fmt.Println("# " + file)
+		}
+		out, _ := yaml.Marshal(merged)
+		fmt.Print(string(out))
+		return
+	}
+
+	var config _Config
+	if res = layers.decode(&config); !res.IsOk() {
+		exitWith(res)
+	}
+	config.fillDefaults()
+	if device, _ := loadDeviceCredential(); device != nil {
+		config.ClientId = device.ClientId
+		layers.sources["client_id"] = "device " + deviceFilePath()
+	}
+	view, res := configView(&config)
+	if !res.IsOk() {
+		exitWith(res)
+	}
+
+	values := map[string]any{}
+	flattenConfig("", view, values)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if *asJson {
+		type entry struct {
+			Value  any    `json:"value"`
+			Source string `json:"source"`
+		}
+		entries := map[string]entry{}
+		for _, key := range keys {
+			entries[key] = entry{values[key], layers.source(key)}
+		}
+		printJson(true, entries)
+		return
+	}
+	for _, key := range keys {
+		value, err := json.Marshal(values[key])
+		if err != nil {
+			logger.Warnw("marshal config value failed", err, "key", key)
+		}
+		fmt.Printf("%s = %s  # %s\n", key, value, layers.source(key))
+	}
+}
+
+// flattenConfig 把嵌套的映射展开为点分路径，列表作为一个值
+func flattenConfig(prefix string, value any, out map[string]any) {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) == 0 {
+		if prefix != "" {
+			out[prefix] = value
+		}
+		return
+	}
+	for key, v := range m {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		flattenConfig(path, v, out)
+	}
+}
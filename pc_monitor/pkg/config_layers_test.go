@@ -0,0 +1,248 @@
+package pkg
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfigLayers 在临时目录中写入系统配置和用户配置(user为空时不写)，返回系统配置路径
+func testConfigLayers(t *testing.T, system, user string, sets ...string) string {
+	dir := t.TempDir()
+	gOptions = Options{DataDir: filepath.Join(dir, "data"), Sets: sets}
+	t.Cleanup(func() { gOptions = Options{} })
+
+	configFile := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(configFile, []byte(system), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		if err := os.MkdirAll(gOptions.DataDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(userConfigFilePath(), []byte(user), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configFile
+}
+
+func TestConfigLayersPrecedence(t *testing.T) {
+	configFile := testConfigLayers(t, `
+api_port: 18081
+language: zh-CN
+break_interval_sec: 3600
+pause:
+  max_min: 120
+  daily_limit: 2
+snooze:
+  durations_min: [5, 10, 15]
+`, `
+language: en
+pause:
+  max_min: 60
+snooze:
+  durations_min: [20]
+`, "pause.daily_limit=1", "api_port=28081")
+	t.Setenv("HN_PAUSE__MAX_MIN", "30")
+	t.Setenv("HN_API_PORT", "19091")
+
+	var config _Config
+	if res := loadConfig(configFile, &config); !res.IsOk() {
+		t.Fatalf("loadConfig: %s", res.Message())
+	}
+	cases := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"system only", config.BreakIntervalSec, 3600},
+		{"user over system", config.Language, "en"},
+		{"env over user", config.Pause.MaxMin, 30},
+		{"flag over system", config.Pause.DailyLimit, 1},
+		{"flag over env", config.ApiPort, "28081"},
+		{"user list replaces system list", len(config.Snooze.DurationsMin), 1},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestConfigLayersSource(t *testing.T) {
+	configFile := testConfigLayers(t, `
+language: zh-CN
+pause:
+  max_min: 120
+`, `
+pause:
+  max_min: 60
+`, "logging.level=debug")
+	t.Setenv("HN_LANGUAGE", "en")
+
+	layers, res := loadConfigLayers(configFile)
+	if !res.IsOk() {
+		t.Fatalf("loadConfigLayers: %s", res.Message())
+	}
+	cases := []struct {
+		path   string
+		source string
+	}{
+		{"language", "env HN_LANGUAGE"},
+		{"pause.max_min", "user " + userConfigFilePath()},
+		{"logging.level", "flag --set logging.level"},
+		{"pause.daily_limit", sourceDefault},
+		{"api_port", sourceDefault},
+	}
+	for _, c := range cases {
+		if source := layers.source(c.path); source != c.source {
+			t.Errorf("source(%s) = %q, want %q", c.path, source, c.source)
+		}
+	}
+	if len(layers.files) != 2 {
+		t.Errorf("files = %v, want system and user config", layers.files)
+	}
+}
+
+func TestConfigLayersReplaceSubtree(t *testing.T) {
+	configFile := testConfigLayers(t, `
+guardian:
+  totp_secret: JBSWY3DPEHPK3PXP
+  daily_quota: 2
+`, "", "guardian=off")
+
+	layers, res := loadConfigLayers(configFile)
+	if !res.IsOk() {
+		t.Fatalf("loadConfigLayers: %s", res.Message())
+	}
+	if layers.merged["guardian"] != "off" {
+		t.Errorf("guardian = %v, want the flag value", layers.merged["guardian"])
+	}
+	// 被整体覆盖的下级不再保留原来的来源
+	if source := layers.source("guardian.daily_quota"); source != "flag --set guardian" {
+		t.Errorf("source(guardian.daily_quota) = %q", source)
+	}
+}
+
+func TestConfigLayersInvalidSet(t *testing.T) {
+	configFile := testConfigLayers(t, "language: en\n", "", "language")
+	if _, res := loadConfigLayers(configFile); res.IsOk() {
+		t.Errorf("--set without a value accepted")
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	cases := []struct {
+		value string
+		want  any
+	}{
+		{"30", 30},
+		{"true", true},
+		{"en", "en"},
+		{"", ""},
+		{"a: b", "a: b"},
+	}
+	for _, c := range cases {
+		if got := parseConfigValue(c.value); got != c.want {
+			t.Errorf("parseConfigValue(%q) = %#v, want %#v", c.value, got, c.want)
+		}
+	}
+	if list, ok := parseConfigValue("[5, 10]").([]any); !ok || len(list) != 2 {
+		t.Errorf("parseConfigValue([5, 10]) = %#v, want a list", list)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	configFile := testConfigLayers(t, `
+language: en
+calendar:
+  sources: [ "https://calendar.example.com/private-0123abcd/basic.ics" ]
+  refresh_sec: 600
+guardian:
+  totp_secret: JBSWY3DPEHPK3PXP
+  daily_quota: 1
+reminders:
+  - name: water
+    unlock: qr
+    unlock_options:
+      codes: [ qr-secret-1, qr-secret-2 ]
+  - name: stretch
+    unlock: phrase
+    unlock_options:
+      phrases: [ "I will stand up now" ]
+      idle_min: 3
+`, "")
+
+	layers, res := loadConfigLayers(configFile)
+	if !res.IsOk() {
+		t.Fatalf("loadConfigLayers: %s", res.Message())
+	}
+	var config _Config
+	if res = layers.decode(&config); !res.IsOk() {
+		t.Fatalf("decode: %s", res.Message())
+	}
+	config.fillDefaults()
+	effective, res := configView(&config)
+	if !res.IsOk() {
+		t.Fatalf("configView: %s", res.Message())
+	}
+
+	secrets := []string{"0123abcd", "JBSWY3DPEHPK3PXP", "qr-secret-1", "qr-secret-2", "I will stand up now"}
+	for name, view := range map[string]map[string]any{"config show": redactConfig(layers.merged), "effective": effective} {
+		out, _ := yaml.Marshal(view)
+		for _, secret := range secrets {
+			if strings.Contains(string(out), secret) {
+				t.Errorf("%s: %q is not masked:\n%s", name, secret, out)
+			}
+		}
+		cases := []struct {
+			path []any
+			want any
+		}{
+			{[]any{"guardian", "totp_secret"}, "***"},
+			{[]any{"guardian", "daily_quota"}, 1},
+			{[]any{"calendar", "sources"}, "***"},
+			{[]any{"calendar", "refresh_sec"}, 600},
+			{[]any{"reminders", 0, "unlock_options", "codes"}, "***"},
+			{[]any{"reminders", 1, "unlock_options", "phrases"}, "***"},
+			{[]any{"reminders", 1, "unlock_options", "idle_min"}, 3},
+		}
+		for _, c := range cases {
+			if got := configValue(view, c.path...); got != c.want {
+				t.Errorf("%s: %v = %v, want %v", name, c.path, got, c.want)
+			}
+		}
+	}
+
+	// 合并结果本身不变，仍可解码出密钥
+	if config.Guardian.TotpSecret != "JBSWY3DPEHPK3PXP" || len(config.Reminders[0].UnlockOpts.Codes) != 2 {
+		t.Errorf("decoded secrets changed: %q, %v", config.Guardian.TotpSecret, config.Reminders[0].UnlockOpts.Codes)
+	}
+
+	// 未配置的密钥保持原样
+	view := redactConfig(map[string]any{"language": "en", "guardian": map[string]any{"totp_secret": ""}})
+	if _, ok := view["calendar"]; ok || view["guardian"].(map[string]any)["totp_secret"] != "" {
+		t.Errorf("redactConfig changed unset secrets: %v", view)
+	}
+}
+
+// configValue 按字段名和列表下标取出配置映射中的值
+func configValue(value any, path ...any) any {
+	for _, key := range path {
+		switch k := key.(type) {
+		case string:
+			m, _ := value.(map[string]any)
+			value = m[k]
+		case int:
+			list, _ := value.([]any)
+			if k >= len(list) {
+				return nil
+			}
+			value = list[k]
+		}
+	}
+	return value
+}
@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"gopkg.in/yaml.v3"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type _ContentConfig struct {
+	TipsFile       string `yaml:"tips_file"`        // 提醒内容文件(yaml/json)，相对路径基于配置文件目录，为空时使用内置内容
+	NoRepeatWindow int    `yaml:"no_repeat_window"` // 最近展示过的N条内容不会重复出现
+}
+
+type _Tip struct {
+	Category string            `yaml:"category" json:"category"` // 分类，如water/stretch/eye
+	Weight   int               `yaml:"weight" json:"weight"`     // 权重，默认为1
+	Text     string            `yaml:"text" json:"text"`         // 内容模板(text/template)
+	Texts    map[string]string `yaml:"texts" json:"texts"`       // 各语言的内容模板，优先于text
+}
+
+type _TipsFile struct {
+	Tips []_Tip `yaml:"tips" json:"tips"`
+}
+
+// ContentStatus 内容模板中可引用的状态
+type ContentStatus struct {
+	Reminder      string    // 提醒名称，如water/eye/stand
+	MinutesWorked int       // 本次连续工作的分钟数
+	BreaksToday   int       // 今日已完成该提醒的次数
+	SnoozesLeft   int       // 今日剩余的稍后提醒次数
+	Now           time.Time // 当前时间
+}
+
+var defaultTips = []_Tip{
+	{Category: "water", Weight: 3, Texts: map[string]string{
+		LangZhCN: "你已经工作了一段时间，请站起来去喝水。",
+		LangEn:   "You have been working for a while. Please stand up and drink some water.",
+	}},
+	{Category: "water", Weight: 2, Texts: map[string]string{
+		LangZhCN: "已经连续工作{{.MinutesWorked}}分钟了，今天才喝了{{.BreaksToday}}次水，去接杯水吧。",
+		LangEn:   "{{.MinutesWorked}} minutes of work in a row and only {{.BreaksToday}} drinks today. Go get some water.",
+	}},
+	{Category: "stretch", Weight: 1, Texts: map[string]string{
+		LangZhCN: "起身活动一下：双手交叉向上伸展10秒，再左右转动肩膀各10次，然后去喝水。",
+		LangEn:   "Stand up and stretch: reach up with your hands clasped for 10 seconds, roll your shoulders 10 times each way, then drink some water.",
+	}},
+	{Category: "eye", Weight: 1, Texts: map[string]string{
+		LangZhCN: "让眼睛休息一下：看向6米外的物体20秒，顺便去喝口水。",
+		LangEn:   "Rest your eyes: look at something 20 feet away for 20 seconds, and grab a drink on the way.",
+	}},
+}
+
+type _CompiledTip struct {
+	tip       _Tip
+	templates map[string]*template.Template
+}
+
+// template 依次查找指定语言、未区分语言、中文的模板
+func (c *_CompiledTip) template(lang string) *template.Template {
+	for _, key := range []string{lang, "", LangZhCN} {
+		if t, ok := c.templates[key]; ok {
+			return t
+		}
+	}
+	for _, t := range c.templates {
+		return t
+	}
+	return nil
+}
+
+// ContentProvider 按权重轮换提醒内容，并避免在最近的窗口内重复
+type ContentProvider struct {
+	mutex  sync.Mutex
+	tips   []*_CompiledTip
+	window int
+	recent []int
+	rand   *rand.Rand
+}
+
+func NewContentProvider(tips []_Tip, noRepeatWindow int) (*ContentProvider, base.Result) {
+	if len(tips) == 0 {
+		return nil, base.INVALID_PARAM.SetMsg("no tips")
+	}
+
+	p := &ContentProvider{
+		window: noRepeatWindow,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i, tip := range tips {
+		if tip.Weight <= 0 {
+			tip.Weight = 1
+		}
+
+		compiled := &_CompiledTip{tip: tip, templates: map[string]*template.Template{}}
+		texts := tip.Texts
+		if tip.Text != "" {
+			texts = map[string]string{"": tip.Text}
+			for lang, text := range tip.Texts {
+				texts[lang] = text
+			}
+		}
+		for lang, text := range texts {
+			t, err := template.New(tip.Category).Parse(text)
+			if err != nil {
+				return nil, base.INVALID_PARAM.AppendErr("invalid tip template at "+strconv.Itoa(i), err)
+			}
+			compiled.templates[lang] = t
+		}
+		if len(compiled.templates) == 0 {
+			return nil, base.INVALID_PARAM.SetMsg("empty tip at " + strconv.Itoa(i))
+		}
+		p.tips = append(p.tips, compiled)
+	}
+
+	// 窗口不能覆盖全部内容，否则无内容可选
+	if p.window >= len(p.tips) {
+		p.window = len(p.tips) - 1
+	}
+	return p, base.SUCCESS
+}
+
+// LoadContentProvider 从yaml/json文件加载内容，file为空时使用内置内容
+func LoadContentProvider(file string, noRepeatWindow int) (*ContentProvider, base.Result) {
+	if file == "" {
+		return NewContentProvider(defaultTips, noRepeatWindow)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read tips file failed", err)
+	}
+
+	var tf _TipsFile
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(data, &tf)
+	} else {
+		err = yaml.Unmarshal(data, &tf)
+	}
+	if err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse tips file failed", err)
+	}
+
+	return NewContentProvider(tf.Tips, noRepeatWindow)
+}
+
+// WithCategory 返回只轮换指定分类内容的provider，分类为空或没有该分类的内容时返回自身
+func (p *ContentProvider) WithCategory(category string) *ContentProvider {
+	if category == "" {
+		return p
+	}
+
+	sub := &ContentProvider{
+		window: p.window,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, tip := range p.tips {
+		if tip.tip.Category == category {
+			sub.tips = append(sub.tips, tip)
+		}
+	}
+	if len(sub.tips) == 0 {
+		logger.Warnw("no tips of category, use all tips", nil, "category", category)
+		return p
+	}
+
+	if sub.window >= len(sub.tips) {
+		sub.window = len(sub.tips) - 1
+	}
+	return sub
+}
+
+// Next 选择下一条内容并用status渲染
+func (p *ContentProvider) Next(status ContentStatus) string {
+	p.mutex.Lock()
+	index := p.pick()
+	p.mutex.Unlock()
+
+	tip := p.tips[index]
+	buf := bytes.Buffer{}
+	if err := tip.template(GetLanguage()).Execute(&buf, status); err != nil {
+		logger.Warnw("render tip failed", err, "category", tip.tip.Category)
+		return T("reminder.message")
+	}
+	return buf.String()
+}
+
+func (p *ContentProvider) pick() int {
+	excluded := map[int]bool{}
+	for _, i := range p.recent {
+		excluded[i] = true
+	}
+
+	total := 0
+	for i, tip := range p.tips {
+		if !excluded[i] {
+			total += tip.tip.Weight
+		}
+	}
+
+	index := 0
+	n := p.rand.Intn(total)
+	for i, tip := range p.tips {
+		if excluded[i] {
+			continue
+		}
+		if n < tip.tip.Weight {
+			index = i
+			break
+		}
+		n -= tip.tip.Weight
+	}
+
+	if p.window > 0 {
+		p.recent = append(p.recent, index)
+		if len(p.recent) > p.window {
+			p.recent = p.recent[len(p.recent)-p.window:]
+		}
+	}
+	return index
+}
+
+func (r *HNReminder) initContent(configFile string) base.Result {
+	file := r.config.Content.TipsFile
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(configFile), file)
+	}
+
+	provider, res := LoadContentProvider(file, r.config.Content.NoRepeatWindow)
+	if !res.IsOk() {
+		return res
+	}
+	r.content = provider
+	return base.SUCCESS
+}
+
+// nextMessage 生成本次提醒的内容，调用方需持有锁
+func (r *HNReminder) nextMessage(rem *_Reminder, now time.Time) string {
+	rollBreaksDay(rem.state, now)
+	r.rollSnoozeDay(now)
+	return rem.content.Next(ContentStatus{
+		Reminder:      rem.def.Name,
+		MinutesWorked: int(now.Sub(rem.state.LastBreakTime).Minutes()),
+		BreaksToday:   rem.state.BreaksToday,
+		SnoozesLeft:   r.config.Snooze.DailyLimit - r.store.data.Snooze.Count,
+		Now:           now,
+	})
+}
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SenderDefault      = "default"      // 启动时传入的sender
+	SenderNotification = "notification" // 系统通知
+	SenderMessageBox   = "msgbox"       // 系统模态弹框
+	SenderOverlay      = "overlay"      // 全屏置顶遮罩
+	SenderConsole      = "console"      // 标准输出
+)
+
+type _EscalationStep struct {
+	AfterSec  int    `yaml:"after_sec"`  // 超时多少秒后进入该级别
+	Sender    string `yaml:"sender"`     // 该级别使用的sender
+	RepeatSec int    `yaml:"repeat_sec"` // 该级别两次提醒的最小间隔，0表示使用always_remind_interval_sec
+}
+
+// EscalationPolicy 根据超时时长选择提醒级别
+type EscalationPolicy struct {
+	steps []_EscalationStep
+}
+
+func NewEscalationPolicy(steps []_EscalationStep) *EscalationPolicy {
+	sorted := make([]_EscalationStep, len(steps))
+	copy(sorted, steps)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].AfterSec < sorted[j].AfterSec
+	})
+	return &EscalationPolicy{steps: sorted}
+}
+
+// Pick 返回超时overdue时应使用的级别，以及距离下一级别的时长(已是最高级别时为-1)
+func (p *EscalationPolicy) Pick(overdue time.Duration) (step _EscalationStep, untilNext time.Duration) {
+	index := 0
+	for i, s := range p.steps {
+		if overdue >= time.Duration(s.AfterSec)*time.Second {
+			index = i
+		}
+	}
+
+	untilNext = -1
+	if index+1 < len(p.steps) {
+		untilNext = time.Duration(p.steps[index+1].AfterSec)*time.Second - overdue
+	}
+	return p.steps[index], untilNext
+}
+
+func (p *EscalationPolicy) validate(senders map[string]MessageSender) base.Result {
+	if len(p.steps) == 0 {
+		return base.INVALID_PARAM.SetMsg("empty escalation steps")
+	}
+
+	for _, s := range p.steps {
+		if _, ok := senders[s.Sender]; !ok {
+			return base.INVALID_PARAM.SetMsg("unknown escalation sender: " + s.Sender)
+		}
+	}
+	return base.SUCCESS
+}
+
+// EscalatingSender 组合多个MessageSender，按超时时长逐级升级提醒方式
+type EscalatingSender struct {
+	policy  *EscalationPolicy
+	senders map[string]MessageSender
+	overdue func() time.Duration
+	now     func() time.Time
+	after   func(time.Duration) <-chan time.Time // 测试中替换，避免依赖真实时间
+
+	mutex   sync.Mutex
+	current MessageSender
+	closed  chan struct{}
+}
+
+func NewEscalatingSender(policy *EscalationPolicy, senders map[string]MessageSender, overdue func() time.Duration) *EscalatingSender {
+	return &EscalatingSender{
+		policy:  policy,
+		senders: senders,
+		overdue: overdue,
+		now:     time.Now,
+		after:   time.After,
+	}
+}
+
+func (s *EscalatingSender) Show(n *Notification) {
+	overdue := s.overdue()
+	step, untilNext := s.policy.Pick(overdue)
+	sender := s.senders[step.Sender]
+
+	closed := make(chan struct{})
+	s.mutex.Lock()
+	s.current = sender
+	s.closed = closed
+	s.mutex.Unlock()
+
+	if untilNext < 0 && n.Urgency < UrgencyCritical {
+		n.Urgency = UrgencyCritical
+	}
+
+	logger.Debugw("escalating reminder", "overdue", overdue, "sender", step.Sender)
+	started := s.now()
+	inner := *n
+	inner.Result = make(chan NotificationResult, 1)
+	sender.Show(&inner)
+
+	result := NotificationResult{Type: ResultNoFeedback}
+	select {
+	case result = <-inner.Result:
+	default:
+	}
+
+	// 非阻塞的sender(如系统通知)按repeat_sec控制重复频率，但不能拖延升级到下一级别
+	if result.Type == ResultNoFeedback && step.RepeatSec > 0 {
+		wait := time.Duration(step.RepeatSec)*time.Second - s.now().Sub(started)
+		if untilNext >= 0 && untilNext < wait {
+			wait = untilNext
+		}
+		if wait > 0 {
+			select {
+			case <-s.after(wait):
+			case <-closed:
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	s.current = nil
+	s.closed = nil
+	s.mutex.Unlock()
+
+	n.reply(result.Type, result.ActionId)
+}
+
+func (s *EscalatingSender) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current != nil {
+		s.current.Close()
+	}
+	if s.closed != nil {
+		close(s.closed)
+		s.closed = nil
+	}
+}
+
+func (r *HNReminder) initEscalation(defaultSender MessageSender) base.Result {
+	senders := map[string]MessageSender{
+		SenderDefault:      defaultSender,
+		SenderNotification: NewNotificationSender(AppName),
+		SenderMessageBox:   NewMessageBoxSender(AppName),
+		SenderOverlay:      NewOverlaySender(AppName, 0),
+		SenderConsole:      NewConsoleSender(AppName),
+	}
+
+	policy := NewEscalationPolicy(r.config.Escalation)
+	if res := policy.validate(senders); !res.IsOk() {
+		return res
+	}
+
+	r.msgSender = NewEscalatingSender(policy, senders, r.getOverdue)
+	return base.SUCCESS
+}
+
+// getOverdue 返回正在展示的提醒已持续的时长
+func (r *HNReminder) getOverdue() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.shown == nil || !r.shown.shouldRemind {
+		return 0
+	}
+	return time.Since(r.shown.remindSince)
+}
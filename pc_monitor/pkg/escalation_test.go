@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender 记录收到的通知，reply为nil时回传ResultNoFeedback(如系统通知)
+type fakeSender struct {
+	mutex  sync.Mutex
+	shown  []Notification
+	closed int
+	reply  *NotificationResult
+}
+
+func (s *fakeSender) Show(n *Notification) {
+	s.mutex.Lock()
+	s.shown = append(s.shown, *n)
+	s.mutex.Unlock()
+	if s.reply != nil {
+		n.reply(s.reply.Type, s.reply.ActionId)
+	} else {
+		n.reply(ResultNoFeedback, "")
+	}
+}
+
+func (s *fakeSender) Close() {
+	s.mutex.Lock()
+	s.closed++
+	s.mutex.Unlock()
+}
+
+func (s *fakeSender) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.shown)
+}
+
+func (s *fakeSender) closedCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// fakeTimer 替换EscalatingSender的时钟：时间固定不走，每次等待的时长发到waits，fire为nil时永不到期
+type fakeTimer struct {
+	now   time.Time
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func newFakeTimer(s *EscalatingSender, waits chan time.Duration, fire chan time.Time) {
+	t := &fakeTimer{now: time.Date(2024, 5, 6, 9, 0, 0, 0, time.Local), waits: waits, fire: fire}
+	s.now = func() time.Time { return t.now }
+	s.after = func(d time.Duration) <-chan time.Time {
+		t.waits <- d
+		return t.fire
+	}
+}
+
+func testSteps() []_EscalationStep {
+	// 故意乱序，NewEscalationPolicy应按after_sec排序
+	return []_EscalationStep{
+		{AfterSec: 300, Sender: "overlay"},
+		{AfterSec: 0, Sender: "notification"},
+		{AfterSec: 60, Sender: "msgbox"},
+	}
+}
+
+func TestEscalationPolicyPick(t *testing.T) {
+	policy := NewEscalationPolicy(testSteps())
+	cases := []struct {
+		overdue   time.Duration
+		sender    string
+		untilNext time.Duration
+	}{
+		{0, "notification", 60 * time.Second},
+		{59 * time.Second, "notification", time.Second},
+		{60 * time.Second, "msgbox", 240 * time.Second},
+		{299 * time.Second, "msgbox", time.Second},
+		{300 * time.Second, "overlay", -1},
+		{time.Hour, "overlay", -1},
+	}
+	for _, c := range cases {
+		step, untilNext := policy.Pick(c.overdue)
+		if step.Sender != c.sender || untilNext != c.untilNext {
+			t.Errorf("Pick(%s) = %s, %s; want %s, %s", c.overdue, step.Sender, untilNext, c.sender, c.untilNext)
+		}
+	}
+}
+
+func TestEscalationPolicyPickBeforeFirstStep(t *testing.T) {
+	// 第一级不从0开始时，未到第一级也使用第一级
+	policy := NewEscalationPolicy([]_EscalationStep{{AfterSec: 30, Sender: "a"}, {AfterSec: 90, Sender: "b"}})
+	step, untilNext := policy.Pick(10 * time.Second)
+	if step.Sender != "a" || untilNext != 80*time.Second {
+		t.Errorf("Pick(10s) = %s, %s; want a, 1m20s", step.Sender, untilNext)
+	}
+}
+
+func TestEscalationPolicyValidate(t *testing.T) {
+	senders := map[string]MessageSender{"notification": &fakeSender{}, "msgbox": &fakeSender{}}
+	cases := []struct {
+		name  string
+		steps []_EscalationStep
+		ok    bool
+	}{
+		{"empty", nil, false},
+		{"unknown sender", []_EscalationStep{{Sender: "notification"}, {AfterSec: 60, Sender: "sms"}}, false},
+		{"known senders", []_EscalationStep{{Sender: "notification"}, {AfterSec: 60, Sender: "msgbox"}}, true},
+	}
+	for _, c := range cases {
+		if res := NewEscalationPolicy(c.steps).validate(senders); res.IsOk() != c.ok {
+			t.Errorf("%s: validate ok = %v, want %v (%s)", c.name, res.IsOk(), c.ok, res.Message())
+		}
+	}
+}
+
+func TestEscalatingSenderUsesStepSender(t *testing.T) {
+	snoozed := &NotificationResult{Type: ResultSnoozed, ActionId: ActionSnooze}
+	cases := []struct {
+		overdue  time.Duration
+		sender   string
+		critical bool // 最高级别强制为紧急
+	}{
+		{10 * time.Second, "notification", false},
+		{2 * time.Minute, "msgbox", false},
+		{10 * time.Minute, "overlay", true},
+	}
+	for _, c := range cases {
+		senders := map[string]MessageSender{
+			"notification": &fakeSender{reply: snoozed},
+			"msgbox":       &fakeSender{reply: snoozed},
+			"overlay":      &fakeSender{reply: snoozed},
+		}
+		s := NewEscalatingSender(NewEscalationPolicy(testSteps()), senders, func() time.Duration { return c.overdue })
+
+		n := NewNotification("title", "message")
+		s.Show(n)
+
+		for name, sender := range senders {
+			fake := sender.(*fakeSender)
+			want := 0
+			if name == c.sender {
+				want = 1
+			}
+			if fake.count() != want {
+				t.Errorf("overdue %s: %s shown %d times, want %d", c.overdue, name, fake.count(), want)
+			}
+		}
+		shown := senders[c.sender].(*fakeSender).shown[0]
+		if critical := shown.Urgency == UrgencyCritical; critical != c.critical {
+			t.Errorf("overdue %s: critical = %v, want %v", c.overdue, critical, c.critical)
+		}
+		if result := <-n.Result; result.Type != ResultSnoozed || result.ActionId != ActionSnooze {
+			t.Errorf("overdue %s: result = %+v, want the sender's snooze result", c.overdue, result)
+		}
+	}
+}
+
+func TestEscalatingSenderRepeat(t *testing.T) {
+	cases := []struct {
+		name    string
+		overdue time.Duration
+		steps   []_EscalationStep
+		reply   *NotificationResult
+		wait    time.Duration // 0表示不等待
+	}{
+		{
+			// 无法回传结果的sender按repeat_sec等待后再返回
+			name:  "wait repeat_sec",
+			steps: []_EscalationStep{{Sender: "a", RepeatSec: 1}},
+			wait:  time.Second,
+		},
+		{
+			// 等待不能拖延升级到下一级别
+			name:    "capped by next step",
+			overdue: 59*time.Second + 800*time.Millisecond,
+			steps:   []_EscalationStep{{Sender: "a", RepeatSec: 30}, {AfterSec: 60, Sender: "b"}},
+			wait:    200 * time.Millisecond,
+		},
+		{
+			name:  "no repeat_sec",
+			steps: []_EscalationStep{{Sender: "a"}},
+		},
+		{
+			// 用户关闭了弹框时不等待
+			name:  "dismissed",
+			steps: []_EscalationStep{{Sender: "a", RepeatSec: 30}},
+			reply: &NotificationResult{Type: ResultDismissed},
+		},
+		{
+			// 用户已作出选择时不等待
+			name:  "sender replied",
+			steps: []_EscalationStep{{Sender: "a", RepeatSec: 30}},
+			reply: &NotificationResult{Type: ResultAction, ActionId: "x"},
+		},
+	}
+	for _, c := range cases {
+		senders := map[string]MessageSender{"a": &fakeSender{reply: c.reply}, "b": &fakeSender{}}
+		overdue := c.overdue
+		s := NewEscalatingSender(NewEscalationPolicy(c.steps), senders, func() time.Duration { return overdue })
+		waits, fire := make(chan time.Duration, 1), make(chan time.Time)
+		close(fire)
+		newFakeTimer(s, waits, fire)
+
+		s.Show(NewNotification("title", "message"))
+		var wait time.Duration
+		select {
+		case wait = <-waits:
+		default:
+		}
+		if wait != c.wait {
+			t.Errorf("%s: waited %s, want %s", c.name, wait, c.wait)
+		}
+	}
+}
+
+func TestEscalatingSenderCloseStopsRepeatWait(t *testing.T) {
+	fake := &fakeSender{}
+	s := NewEscalatingSender(NewEscalationPolicy([]_EscalationStep{{Sender: "a", RepeatSec: 30}}),
+		map[string]MessageSender{"a": fake}, func() time.Duration { return 0 })
+	// 等待永不到期，只能被Close结束
+	waits := make(chan time.Duration)
+	newFakeTimer(s, waits, nil)
+
+	done := make(chan struct{})
+	go func() {
+		s.Show(NewNotification("title", "message"))
+		close(done)
+	}()
+	if wait := <-waits; wait != 30*time.Second {
+		t.Errorf("waiting %s, want 30s", wait)
+	}
+	s.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Show did not return after Close")
+	}
+	if closed := fake.closedCount(); closed != 1 {
+		t.Errorf("current sender closed %d times, want 1", closed)
+	}
+}
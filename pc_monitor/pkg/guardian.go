@@ -0,0 +1,239 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/lxn/walk"
+	"github.com/patstar123/go-base"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpStepSec = 30
+	totpDigits  = 6
+	totpSkew    = 1 // 允许前后各一个时间步的时钟误差
+
+	maxGuardianLockout = 24 * time.Hour
+)
+
+type _GuardianConfig struct {
+	TotpSecret  string `yaml:"totp_secret" json:"-" secret:"true"` // 与监护人共享的TOTP密钥(base32)，为空时不启用离线解锁码
+	DailyQuota  int    `yaml:"daily_quota"`                        // 每天最多可使用解锁码的次数，默认1
+	MaxFailures int    `yaml:"max_failures"`                       // 连续输错多少次后锁定，默认5
+	LockoutMin  int    `yaml:"lockout_min"`                        // 首次锁定的分钟数，之后每次锁定时长翻倍(最长1天)，默认15
+}
+
+// _GuardianState 离线解锁码的使用情况
+type _GuardianState struct {
+	Day         string    `json:"day"`         // 计数所属日期(2006-01-02)
+	Used        int       `json:"used"`        // 当日已使用次数
+	LastStep    int64     `json:"lastStep"`    // 最后一次使用的时间步，同一个解锁码不能重复使用
+	Failures    int       `json:"failures"`    // 连续输错的次数，成功后清零，防止穷举解锁码
+	LockedUntil time.Time `json:"lockedUntil"` // 锁定的截止时间
+}
+
+func (c *_GuardianConfig) fillDefaults() {
+	if c.DailyQuota <= 0 {
+		c.DailyQuota = 1
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 5
+	}
+	if c.LockoutMin <= 0 {
+		c.LockoutMin = 15
+	}
+}
+
+// lockout 连续输错failures次后的锁定时长，不足max_failures次时为0
+func (c *_GuardianConfig) lockout(failures int) time.Duration {
+	if failures < c.MaxFailures || failures%c.MaxFailures != 0 {
+		return 0
+	}
+
+	lockout := time.Duration(c.LockoutMin) * time.Minute
+	for i := failures / c.MaxFailures; i > 1 && lockout < maxGuardianLockout; i-- {
+		lockout *= 2
+	}
+	if lockout > maxGuardianLockout {
+		lockout = maxGuardianLockout
+	}
+	return lockout
+}
+
+func (c *_GuardianConfig) enabled() bool {
+	return c.TotpSecret != ""
+}
+
+// UnlockWithGuardianCode 用监护人报出的6位解锁码解除所有到期的提醒，无需连接消息路由
+func (r *HNReminder) UnlockWithGuardianCode(code string) base.Result {
+	if !r.config.Guardian.enabled() {
+		return base.ACTION_ILLEGAL.SetMsg(T("guardian.disabled"))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	targets, res := r.findReminders("", func(rem *_Reminder) bool { return rem.shouldRemind })
+	if !res.IsOk() {
+		return res
+	}
+	if len(targets) == 0 {
+		return base.ACTION_ILLEGAL.SetMsg(T("unlock.no_target"))
+	}
+
+	now := time.Now()
+	state := &r.store.data.Guardian
+	if now.Before(state.LockedUntil) {
+		r.audit(AuditUnlockReject, AuditActorGuardian, "", "locked out")
+		return base.ACTION_ILLEGAL.SetMsg(T("guardian.locked", FormatDuration(state.LockedUntil.Sub(now))))
+	}
+	if day := now.Format("2006-01-02"); state.Day != day {
+		state.Day = day
+		state.Used = 0
+	}
+	if state.Used >= r.config.Guardian.DailyQuota {
+		r.audit(AuditUnlockReject, AuditActorGuardian, "", "daily quota used up")
+		return base.ACTION_ILLEGAL.SetMsg(T("guardian.used_up"))
+	}
+
+	step, res := verifyTotp(r.config.Guardian.TotpSecret, code, now)
+	if !res.IsOk() {
+		state.Failures++
+		reason := fmt.Sprintf("bad code (%d in a row)", state.Failures)
+		if lockout := r.config.Guardian.lockout(state.Failures); lockout > 0 {
+			state.LockedUntil = now.Add(lockout)
+			reason += ", locked for " + lockout.String()
+			res = base.ACTION_ILLEGAL.SetMsg(T("guardian.locked", FormatDuration(lockout)))
+		}
+		r.store.save()
+		logger.Infow("HydrateNow: guardian code rejected", "reason", res.Message(), "failures", state.Failures)
+		r.audit(AuditUnlockReject, AuditActorGuardian, "", reason)
+		return res
+	}
+	if step <= state.LastStep {
+		r.audit(AuditUnlockReject, AuditActorGuardian, "", "code reused")
+		return base.ACTION_ILLEGAL.SetMsg(T("guardian.reused"))
+	}
+
+	state.Used++
+	state.LastStep = step
+	state.Failures = 0
+	var names []string
+	for _, rem := range targets {
+		r.completeReminder(rem, now)
+		names = append(names, rem.def.Name)
+	}
+	r.store.save()
+	if r.shown != nil && !r.shown.shouldRemind {
+		r.closeReminder()
+	}
+
+	logger.Infow("HydrateNow: unlocked by guardian code", "reminders", names, "used", state.Used)
+	r.audit(AuditUnlock, AuditActorGuardian, strings.Join(names, ","), fmt.Sprintf("%d/%d used today", state.Used, r.config.Guardian.DailyQuota))
+	return base.SUCCESS.SetData(gin.H{"reminders": names, "remaining": r.config.Guardian.DailyQuota - state.Used})
+}
+
+// GuardianStatus 监护人解锁码是否启用及今日剩余次数
+type GuardianStatus struct {
+	Enabled     bool      `json:"enabled"`
+	Remaining   int       `json:"remaining"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty"` // 连续输错被锁定时的截止时间
+}
+
+func (r *HNReminder) GetGuardianStatus() GuardianStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.config.Guardian.enabled() {
+		return GuardianStatus{}
+	}
+	now := time.Now()
+	status := GuardianStatus{Enabled: true, Remaining: r.config.Guardian.DailyQuota}
+	state := r.store.data.Guardian
+	if state.Day == now.Format("2006-01-02") {
+		status.Remaining -= state.Used
+	}
+	if now.Before(state.LockedUntil) {
+		status.LockedUntil = state.LockedUntil
+	}
+	return status
+}
+
+// PromptGuardianCode 弹出输入框让用户输入监护人报出的解锁码
+func (r *HNReminder) PromptGuardianCode() base.Result {
+	if !r.config.Guardian.enabled() {
+		return base.ACTION_ILLEGAL.SetMsg(T("guardian.disabled"))
+	}
+
+	go func() {
+		code, ok := promptInput(AppName, T("guardian.prompt"))
+		if !ok {
+			return
+		}
+		if res := r.UnlockWithGuardianCode(code); !res.IsOk() {
+			walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK|walk.MsgBoxIconWarning)
+		}
+	}()
+	return base.SUCCESS
+}
+
+// verifyTotp 按RFC 6238校验解锁码，成功时返回匹配的时间步
+func verifyTotp(secret, code string, now time.Time) (int64, base.Result) {
+	key, err := decodeTotpSecret(secret)
+	if err != nil {
+		return 0, base.INVALID_PARAM.AppendErr("invalid totp_secret", err)
+	}
+
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpStepSec
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(code), []byte(totpCode(key, step))) {
+			return step, base.SUCCESS
+		}
+	}
+	return 0, base.INVALID_PARAM.SetMsg(T("guardian.bad_code"))
+}
+
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func decodeTotpSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// NewTotpSecret 生成新的TOTP密钥，输出供配置文件使用的密钥和供监护人手机验证器导入的地址
+func NewTotpSecret(loadBuilding func()) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		fmt.Println("generate secret failed:", err)
+		return
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", AppName)
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpStepSec))
+
+	fmt.Println("guardian:")
+	fmt.Println("  totp_secret:", secret)
+	fmt.Println()
+	fmt.Println("otpauth://totp/" + AppName + "?" + query.Encode())
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238附录B的测试密钥"12345678901234567890"
+const testTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	key, err := decodeTotpSecret(testTotpSecret)
+	if err != nil {
+		t.Fatalf("decodeTotpSecret: %v", err)
+	}
+
+	// RFC 6238的SHA1测试向量(8位)取后6位
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		if got := totpCode(key, c.unix/totpStepSec); got != c.code {
+			t.Errorf("totpCode at %d = %s, want %s", c.unix, got, c.code)
+		}
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	key, _ := decodeTotpSecret(testTotpSecret)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpStepSec
+
+	cases := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{"current", testTotpSecret, totpCode(key, current), current, true},
+		{"previous step", testTotpSecret, totpCode(key, current-1), current - 1, true},
+		{"next step", testTotpSecret, totpCode(key, current+1), current + 1, true},
+		{"too old", testTotpSecret, totpCode(key, current-2), 0, false},
+		{"spaces and lower case secret", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", " " + totpCode(key, current) + " ", current, true},
+		{"wrong code", testTotpSecret, "000000", 0, false},
+		{"empty code", testTotpSecret, "", 0, false},
+		{"invalid secret", "not base32!", totpCode(key, current), 0, false},
+	}
+	for _, c := range cases {
+		step, res := verifyTotp(c.secret, c.code, now)
+		if res.IsOk() != c.ok || step != c.step {
+			t.Errorf("%s: verifyTotp = %d, %v (%s); want %d, %v", c.name, step, res.IsOk(), res.Message(), c.step, c.ok)
+		}
+	}
+}
+
+func TestGuardianLockout(t *testing.T) {
+	config := _GuardianConfig{}
+	config.fillDefaults()
+
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{4, 0},
+		{5, 15 * time.Minute},
+		{6, 0},
+		{10, 30 * time.Minute},
+		{15, time.Hour},
+		{50, 24 * time.Hour},
+		{500, 24 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := config.lockout(c.failures); got != c.want {
+			t.Errorf("lockout(%d) = %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
+
+func TestUnlockWithGuardianCodeLocksOut(t *testing.T) {
+	gOptions = Options{DataDir: t.TempDir()}
+	defer func() { gOptions = Options{} }()
+
+	r := &HNReminder{store: &_Store{data: _StoreData{Reminders: map[string]*_ReminderState{}}}}
+	r.config.Guardian = _GuardianConfig{TotpSecret: testTotpSecret, DailyQuota: 3}
+	r.config.fillDefaults()
+	rem := &_Reminder{def: _ReminderDef{Name: "water"}, state: r.store.reminder("water"), shouldRemind: true}
+	r.reminders = []*_Reminder{rem}
+
+	for i := 1; i <= r.config.Guardian.MaxFailures; i++ {
+		if res := r.UnlockWithGuardianCode("000000"); res.IsOk() {
+			t.Fatalf("wrong code %d accepted", i)
+		}
+	}
+	state := r.store.data.Guardian
+	if state.Failures != 5 || time.Until(state.LockedUntil) < 14*time.Minute {
+		t.Fatalf("after 5 wrong codes: failures %d, locked until %s", state.Failures, state.LockedUntil)
+	}
+
+	// 锁定期间正确的解锁码也被拒绝
+	key, _ := decodeTotpSecret(testTotpSecret)
+	code := totpCode(key, time.Now().Unix()/totpStepSec)
+	if res := r.UnlockWithGuardianCode(code); res.IsOk() || !rem.shouldRemind {
+		t.Fatalf("valid code accepted while locked out")
+	}
+	if status := r.GetGuardianStatus(); status.LockedUntil.IsZero() {
+		t.Errorf("status does not report the lockout")
+	}
+
+	// 锁定结束后可以使用，成功后清零
+	r.store.data.Guardian.LockedUntil = time.Now().Add(-time.Second)
+	if res := r.UnlockWithGuardianCode(code); !res.IsOk() || rem.shouldRemind {
+		t.Fatalf("valid code after lockout = %s", res.Message())
+	}
+	if r.store.data.Guardian.Failures != 0 {
+		t.Errorf("failures = %d after success, want 0", r.store.data.Guardian.Failures)
+	}
+}
@@ -0,0 +1,273 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	auditlog "lx/funny/hydrate/audit_log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上报给监护人的事件
+const (
+	EventOffline     = "offline"      // 程序未运行(被结束或电脑关机)
+	EventSuspended   = "suspended"    // 电脑休眠或系统时间被调快
+	EventClockJump   = "clock_jump"   // 系统时间被调慢，会推迟提醒
+	EventAuditReset  = "audit_reset"  // 审计日志校验失败(被修改、截断或删除)，已归档并开始新的一段
+	EventNoHeartbeat = "no_heartbeat" // 配对后启动时没有上次心跳(状态文件被删除)，无法计算停止运行的时长
+)
+
+const maxPendingEvents = 50
+
+type _HeartbeatConfig struct {
+	IntervalSec  int `yaml:"interval_sec"`   // 心跳间隔，默认60
+	MinGapSec    int `yaml:"min_gap_sec"`    // 工作时段内停止运行超过该时长才上报，默认10分钟
+	ClockJumpSec int `yaml:"clock_jump_sec"` // 系统时间被调整超过该时长视为篡改，默认2分钟
+}
+
+func (c *_HeartbeatConfig) fillDefaults() {
+	if c.IntervalSec <= 0 {
+		c.IntervalSec = 60
+	}
+	if c.MinGapSec <= 0 {
+		c.MinGapSec = 10 * 60
+	}
+	if c.ClockJumpSec <= 0 {
+		c.ClockJumpSec = 2 * 60
+	}
+}
+
+// GuardianEvent 停止运行/时间篡改事件，上报消息路由后转给监护人
+type GuardianEvent struct {
+	Id         string    `json:"id"` // 本机生成，上报成功后按ID从队列中移除
+	Type       string    `json:"type"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	WorkingSec int       `json:"workingSec"` // 其中处于工作时段的秒数
+	Message    string    `json:"message"`
+}
+
+// _HeartbeatState 最后一次心跳的时间及尚未上报的事件
+type _HeartbeatState struct {
+	LastBeat      time.Time       `json:"lastBeat"`
+	Pending       []GuardianEvent `json:"pending"`
+	ReportedReset string          `json:"reportedReset,omitempty"` // 已上报的审计日志chain_reset记录的哈希
+}
+
+// checkDowntime 启动时根据最后一次心跳计算停止运行的时段，调用方需持有锁
+func (r *HNReminder) checkDowntime(now time.Time) {
+	state := &r.store.data.Heartbeat
+	last := state.LastBeat
+	state.LastBeat = now
+	r.lastBeat = now
+	defer r.store.save()
+	if last.IsZero() {
+		if r.device != nil {
+			r.reportMissingHeartbeat(now)
+		}
+		return
+	}
+
+	jump := time.Duration(r.config.Heartbeat.ClockJumpSec) * time.Second
+	if now.Before(last.Add(-jump)) {
+		r.addGuardianEvent(GuardianEvent{Type: EventClockJump, From: last, To: now,
+			Message: "system clock is " + shortDuration(last.Sub(now)) + " behind the last heartbeat"})
+		return
+	}
+
+	interval := time.Duration(r.config.Heartbeat.IntervalSec) * time.Second
+	if now.Sub(last) > interval {
+		r.checkGap(EventOffline, last, now, "monitor was down")
+	}
+}
+
+// reportMissingHeartbeat 心跳保存在临时目录的状态文件中，删除它就无法计算停止运行的时长，
+// 因此配对后每次没有上次心跳的启动都上报，并以审计日志的最后一条记录作为停止时间的参考，调用方需持有锁
+func (r *HNReminder) reportMissingHeartbeat(now time.Time) {
+	event := GuardianEvent{Type: EventNoHeartbeat, From: now, To: now, Message: "no prior heartbeat, the state file is missing"}
+	if r.auditLog != nil {
+		if entries, res := r.auditLog.Query("", time.Time{}, now); res.IsOk() && len(entries) > 0 {
+			event.From = entries[len(entries)-1].Time
+			event.Message += ", last audit entry " + shortDuration(now.Sub(event.From)) + " ago"
+		}
+	}
+	r.addGuardianEvent(event)
+}
+
+// heartbeatLoop 定时记录心跳，检测系统时间被调整，并上报未上报的事件
+func (r *HNReminder) heartbeatLoop() {
+	ticker := time.NewTicker(time.Duration(r.config.Heartbeat.IntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for r.running {
+		<-ticker.C
+		r.heartbeat(time.Now())
+		r.reportGuardianEvents()
+	}
+}
+
+func (r *HNReminder) heartbeat(now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// 墙上时间与单调时间的差值即系统时间的调整量(休眠期间单调时间不增加)
+	skew := now.Round(0).Sub(r.lastBeat.Round(0)) - now.Sub(r.lastBeat)
+	jump := time.Duration(r.config.Heartbeat.ClockJumpSec) * time.Second
+	if skew < -jump {
+		r.addGuardianEvent(GuardianEvent{Type: EventClockJump, From: r.lastBeat.Round(0), To: now.Round(0),
+			Message: "system clock was set back by " + shortDuration(-skew)})
+	} else if skew > jump {
+		r.checkGap(EventSuspended, now.Add(-skew).Round(0), now.Round(0), "computer was suspended or the clock was set forward")
+	}
+
+	r.lastBeat = now
+	r.store.data.Heartbeat.LastBeat = now.Round(0)
+	r.store.save()
+}
+
+// checkGap 工作时段内的停止时长超过min_gap_sec时记录事件，调用方需持有锁
+func (r *HNReminder) checkGap(kind string, from, to time.Time, what string) {
+	working := to.Sub(from)
+	if r.schedule != nil {
+		working = r.schedule.WorkingBetween(from, to)
+	}
+	if working < time.Duration(r.config.Heartbeat.MinGapSec)*time.Second {
+		logger.Infow("HydrateNow: downtime outside working hours", "type", kind, "from", from, "to", to)
+		return
+	}
+
+	message := what + " for " + shortDuration(to.Sub(from))
+	if working != to.Sub(from) {
+		message += " (" + shortDuration(working) + " in working hours)"
+	}
+	r.addGuardianEvent(GuardianEvent{Type: kind, From: from, To: to, WorkingSec: int(working.Seconds()), Message: message})
+}
+
+// addGuardianEvent 记录审计日志并加入待上报队列，调用方需持有锁
+func (r *HNReminder) addGuardianEvent(event GuardianEvent) {
+	logger.Warnw("HydrateNow: "+event.Message, nil, "type", event.Type, "from", event.From, "to", event.To)
+	r.audit(AuditTamper+event.Type, "system", "", event.Message)
+	r.queueGuardianEvent(event)
+}
+
+// checkAuditReset 审计日志(由本程序或守护进程打开时)校验失败会以chain_reset记录开始新的一段，
+// 启动时把尚未上报的这条记录转给监护人，调用方需持有锁
+func (r *HNReminder) checkAuditReset() {
+	if r.auditLog == nil {
+		return
+	}
+	entries, res := r.auditLog.Query("", time.Time{}, time.Time{})
+	if !res.IsOk() || len(entries) == 0 {
+		return
+	}
+	first := entries[0]
+	state := &r.store.data.Heartbeat
+	if first.Event != auditlog.EventChainReset || first.Hash == state.ReportedReset {
+		return
+	}
+
+	logger.Warnw("HydrateNow: audit log was reset", nil, "reason", first.Result, "prevHead", first.Target)
+	state.ReportedReset = first.Hash
+	r.queueGuardianEvent(GuardianEvent{Type: EventAuditReset, From: first.Time, To: first.Time,
+		Message: "audit log was reset: " + first.Result})
+}
+
+// queueGuardianEvent 加入待上报队列，调用方需持有锁
+func (r *HNReminder) queueGuardianEvent(event GuardianEvent) {
+	if event.Id == "" {
+		event.Id = newGuardianEventId()
+	}
+	state := &r.store.data.Heartbeat
+	state.Pending = append(state.Pending, event)
+	if len(state.Pending) > maxPendingEvents {
+		state.Pending = state.Pending[len(state.Pending)-maxPendingEvents:]
+	}
+	r.store.save()
+}
+
+// reportGuardianEvents 把待上报的事件发给消息路由，失败时下次心跳重试；
+// 路由只接受设备凭证上报，未配对时事件保留在队列中，配对后再上报
+func (r *HNReminder) reportGuardianEvents() {
+	if r.config.RouterUrl == "" || r.device == nil {
+		return
+	}
+	api, res := routerApiUrl(r.config.RouterUrl, "/events")
+	if !res.IsOk() {
+		return
+	}
+
+	r.mutex.Lock()
+	state := &r.store.data.Heartbeat
+	// 旧版本保存的事件没有ID
+	for i := range state.Pending {
+		if state.Pending[i].Id == "" {
+			state.Pending[i].Id = newGuardianEventId()
+		}
+	}
+	pending := append([]GuardianEvent(nil), state.Pending...)
+	r.mutex.Unlock()
+
+	sent := map[string]bool{}
+	for _, event := range pending {
+		query := url.Values{
+			"clientId":   {r.config.ClientId},
+			"type":       {event.Type},
+			"from":       {strconv.FormatInt(event.From.Unix(), 10)},
+			"to":         {strconv.FormatInt(event.To.Unix(), 10)},
+			"workingSec": {strconv.Itoa(event.WorkingSec)},
+			"message":    {event.Message},
+		}
+		if res = callRouter(r.routerTls, http.MethodPost, api+"?"+query.Encode(), r.routerHeader(), nil); !res.IsOk() {
+			logger.Warnw("report guardian event failed", res, "type", event.Type)
+			break
+		}
+		sent[event.Id] = true
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.removeGuardianEvents(sent)
+	logger.Infow("reported guardian events", "count", len(sent))
+}
+
+// removeGuardianEvents 按ID移除已上报的事件，上报期间队列可能加入新事件或被截断，调用方需持有锁
+func (r *HNReminder) removeGuardianEvents(sent map[string]bool) {
+	state := &r.store.data.Heartbeat
+	pending := state.Pending[:0]
+	for _, event := range state.Pending {
+		if !sent[event.Id] {
+			pending = append(pending, event)
+		}
+	}
+	state.Pending = pending
+	r.store.save()
+}
+
+func newGuardianEventId() string {
+	id, err := randomHex(8)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
+
+// shortDuration 上报给路由的时长(如3h5m)，不随界面语言变化
+func shortDuration(d time.Duration) string {
+	s := d.Round(time.Minute).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	if s == "0s" {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	auditlog "lx/funny/hydrate/audit_log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testHeartbeatReminder(t *testing.T) *HNReminder {
+	gOptions = Options{DataDir: t.TempDir()}
+	t.Cleanup(func() { gOptions = Options{} })
+
+	r := &HNReminder{store: &_Store{}}
+	r.config.ClientId = "bob"
+	r.config.Heartbeat.fillDefaults()
+	return r
+}
+
+func TestCheckAuditReset(t *testing.T) {
+	r := testHeartbeatReminder(t)
+	key := []byte("device-1.0123456789abcdef")
+	file := filepath.Join(gOptions.DataDir, auditFileName)
+
+	r.auditLog, _ = auditlog.Open(file, key)
+	r.audit(AuditUnlock, "code", "water", "ok")
+	r.checkAuditReset()
+	if len(r.store.data.Heartbeat.Pending) != 0 {
+		t.Fatalf("intact log reported: %+v", r.store.data.Heartbeat.Pending)
+	}
+
+	// 删除head文件后重新打开，开始新的一段并上报一次
+	os.Remove(file + ".head")
+	r.auditLog, _ = auditlog.Open(file, key)
+	r.checkAuditReset()
+	pending := r.store.data.Heartbeat.Pending
+	if len(pending) != 1 || pending[0].Type != EventAuditReset {
+		t.Fatalf("pending = %+v, want one %s event", pending, EventAuditReset)
+	}
+	r.checkAuditReset()
+	if len(r.store.data.Heartbeat.Pending) != 1 {
+		t.Errorf("reset reported twice: %+v", r.store.data.Heartbeat.Pending)
+	}
+}
+
+func TestCheckDowntimeWithoutHeartbeat(t *testing.T) {
+	// 审计记录的时间取自time.Now()，启动时间设在其3小时后
+	start := time.Now()
+	now := start.Add(3 * time.Hour)
+	cases := []struct {
+		name    string
+		paired  bool
+		audited bool
+		events  int
+		from    time.Time
+	}{
+		{"unpaired first start", false, false, 0, time.Time{}},
+		{"paired without audit log", true, false, 1, now},
+		{"paired with audit log", true, true, 1, start},
+	}
+	for _, c := range cases {
+		r := testHeartbeatReminder(t)
+		if c.paired {
+			r.device = &_DeviceCredential{ClientId: "bob", Credential: "device-1.secret"}
+		}
+		if c.audited {
+			r.auditLog, _ = auditlog.Open(filepath.Join(gOptions.DataDir, auditFileName), []byte("device-1.secret"))
+			r.audit(AuditUnlock, "code", "water", "ok")
+		}
+
+		r.checkDowntime(now)
+		pending := r.store.data.Heartbeat.Pending
+		if len(pending) != c.events {
+			t.Errorf("%s: pending = %+v, want %d events", c.name, pending, c.events)
+			continue
+		}
+		if c.events > 0 {
+			if pending[0].Type != EventNoHeartbeat || pending[0].Id == "" {
+				t.Errorf("%s: event = %+v, want %s with an id", c.name, pending[0], EventNoHeartbeat)
+			}
+			if d := pending[0].From.Sub(c.from); d < -time.Minute || d > time.Minute {
+				t.Errorf("%s: from = %v, want about %v", c.name, pending[0].From, c.from)
+			}
+		}
+	}
+}
+
+// 上报期间队列被截断时，按ID只移除已上报的事件
+func TestRemoveGuardianEvents(t *testing.T) {
+	r := testHeartbeatReminder(t)
+	for i := 0; i < maxPendingEvents; i++ {
+		r.queueGuardianEvent(GuardianEvent{Type: EventOffline})
+	}
+	sent := map[string]bool{}
+	for _, event := range r.store.data.Heartbeat.Pending[:3] {
+		sent[event.Id] = true
+	}
+	oldest := r.store.data.Heartbeat.Pending[0].Id
+
+	// 上报期间又加入两个事件，最早的两个被挤出队列
+	r.queueGuardianEvent(GuardianEvent{Type: EventClockJump})
+	r.queueGuardianEvent(GuardianEvent{Type: EventClockJump})
+	r.removeGuardianEvents(sent)
+
+	pending := r.store.data.Heartbeat.Pending
+	if len(pending) != maxPendingEvents-1 {
+		t.Fatalf("pending = %d events, want %d", len(pending), maxPendingEvents-1)
+	}
+	for _, event := range pending {
+		if sent[event.Id] || event.Id == oldest {
+			t.Errorf("sent event %s still pending", event.Id)
+		}
+	}
+	if pending[len(pending)-1].Type != EventClockJump || pending[len(pending)-2].Type != EventClockJump {
+		t.Errorf("events queued while reporting were dropped")
+	}
+}
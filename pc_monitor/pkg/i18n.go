@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	LangAuto = "auto"
+	LangZhCN = "zh-CN"
+	LangEn   = "en"
+)
+
+// 以"key.one"/"key.other"区分单复数，中文无需区分时只提供"key.other"
+var catalogs = map[string]map[string]string{
+	LangZhCN: {
+		"app.running":            "请勿重复运行",
+		"autostart.ask":          "是否添加为开机自启？",
+		"reminder.message":       "你已经工作了一段时间，请站起来去喝水。",
+		"reminder.unknown":       "未知的提醒: %s",
+		"tray.tooltip":           "多走动多喝水",
+		"tray.tooltip.due":       "多走动多喝水(请尽快打卡,距离下次提醒还有%v)",
+		"tray.tooltip.next":      "多走动多喝水(距离下次休息还有%v)",
+		"tray.tooltip.off":       "多走动多喝水(非工作时间)",
+		"tray.tooltip.off_until": "多走动多喝水(非工作时间，%v开始计时)",
+		"tray.tooltip.deferred":  "多走动多喝水(会议中，提醒推迟到%v (%v))",
+		"tray.tooltip.paused":    "多走动多喝水(已暂停，%v恢复计时)",
+		"tray.quit":              "退出",
+		"tray.quit.tip":          "退出程序",
+		"tray.quit.denied":       "不允许退出(强制结束会被记录并通知监护人)",
+		"tray.snooze":            "稍后提醒",
+		"tray.snooze.tip":        "推迟本次休息提醒(每日次数有限)",
+		"tray.pomodoro":          "番茄钟",
+		"tray.pomodoro.tip":      "专注/休息循环，休息阶段打卡后才能开始下个专注",
+		"tray.pomodoro.start":    "开始",
+		"tray.pomodoro.pause":    "暂停",
+		"tray.pomodoro.resume":   "继续",
+		"tray.pomodoro.stop":     "停止",
+		"pomodoro.tip.focus":     "番茄钟: 第%d个专注，剩余%v",
+		"pomodoro.tip.break":     "番茄钟: 休息中，%v后开始下个专注",
+		"pomodoro.tip.scan":      "番茄钟: 休息中(剩余%v)，请打卡后开始下个专注",
+		"pomodoro.tip.paused":    "番茄钟: 已暂停，当前阶段剩余%v",
+		"pomodoro.running":       "番茄钟已在运行",
+		"pomodoro.not_running":   "番茄钟未开始",
+		"pomodoro.paused":        "番茄钟已暂停",
+		"pomodoro.not_paused":    "番茄钟未暂停",
+		"pomodoro.bad_action":    "不支持的番茄钟操作: %s",
+		"snooze.not_due":         "还没到休息时间",
+		"snooze.bad_duration":    "不支持的稍后提醒时长: %v",
+		"snooze.exhausted":       "今日稍后提醒次数已用完",
+		"snooze.action":          "%v后提醒(今日剩余%d次)",
+		"pause.bad_duration":     "暂停时长需在1分钟到%v之间",
+		"pause.due":              "有提醒已到期，请先完成再暂停",
+		"pause.used_up":          "今日暂停次数已用完",
+		"pause.not_paused":       "当前没有暂停",
+		"msgbox.no_hint":         "选择“否”: %s",
+		"overlay.ok":             "知道了",
+		"unlock.hint.nfc":        "请去扫描NFC标签解除提醒",
+		"unlock.hint.qr":         "请用手机去扫描二维码解除提醒",
+		"unlock.hint.phrase":     "请输入以下短语解除提醒: %s",
+		"unlock.hint.idle":       "请离开电脑%v(期间不要操作键盘鼠标)解除提醒",
+		"unlock.action.phrase":   "输入短语",
+		"unlock.phrase.defaults": "我已经站起来喝过水了|久坐伤身，起来走走|多喝水少熬夜",
+		"unlock.mismatch":        "该提醒不能用这种方式解除",
+		"unlock.bad_tag":         "不是该提醒的标签",
+		"unlock.bad_code":        "无效的二维码",
+		"unlock.bad_phrase":      "输入的短语不正确",
+		"unlock.no_target":       "没有可以这样解除的提醒",
+		"unlock.hint.sequence":   "请依次扫描标签: %s",
+		"unlock.same_tag":        "不能连续两次扫描同一个标签，请换一个",
+		"unlock.wrong_step":      "顺序不对，下一个应扫描: %s",
+		"unlock.progress":        "已完成%s，请继续扫描: %s",
+		"unlock.progress.within": "已完成%s，请在%[3]v内扫描: %[2]s",
+		"unlock.not_away":        "扫描前没有离开电脑足够久(需要%v，实际%v)",
+		"unlock.away_unknown":    "无法获取电脑的输入活动(是否以服务方式运行?)，配置了away.min_sec时不能扫描解除",
+		"tag.missing":            "缺少标签ID",
+		"tag.unknown":            "未登记的标签",
+		"tag.revoked":            "该标签已作废",
+		"tag.bad_sig":            "标签签名无效，请重新写入标签",
+		"tag.by_router":          "标签由消息路由管理，请在路由上操作",
+		"tray.unlock.phrase":     "输入短语解除提醒",
+		"tray.unlock.phrase.tip": "输入提醒中的短语以解除提醒",
+		"tray.guardian":          "输入监护人解锁码",
+		"tray.guardian.tip":      "无法连接消息路由时，输入监护人报出的6位解锁码解除提醒",
+		"guardian.prompt":        "请输入监护人手机验证器上的6位解锁码:",
+		"guardian.disabled":      "未配置监护人解锁码(guardian.totp_secret)",
+		"guardian.bad_code":      "解锁码不正确或已过期",
+		"guardian.reused":        "该解锁码已使用过，请等待下一个",
+		"guardian.used_up":       "今天的解锁码次数已用完",
+		"guardian.locked":        "解锁码输错次数过多，请%s后再试",
+		"pair.code":              "配对码: %s (%v内有效)，请用账号确认:",
+		"pair.done":              "配对成功: 客户端%s，设备%s",
+		"pair.expired":           "配对码已过期，请重新配对",
+		"pair.removed":           "已删除设备凭证",
+		"duration.sep":           "",
+		"unit.hour.other":        "%d小时",
+		"unit.minute.other":      "%d分钟",
+		"unit.second.other":      "%d秒",
+	},
+	LangEn: {
+		"app.running":            "HydrateNow is already running",
+		"autostart.ask":          "Start HydrateNow automatically when you log in?",
+		"reminder.message":       "You have been working for a while. Please stand up and drink some water.",
+		"reminder.unknown":       "Unknown reminder: %s",
+		"tray.tooltip":           "Move more, drink more",
+		"tray.tooltip.due":       "Move more, drink more (please check in soon, next reminder in %v)",
+		"tray.tooltip.next":      "Move more, drink more (next break in %v)",
+		"tray.tooltip.off":       "Move more, drink more (off duty)",
+		"tray.tooltip.off_until": "Move more, drink more (off duty, timer starts at %v)",
+		"tray.tooltip.deferred":  "Move more, drink more (deferred until %v (%v))",
+		"tray.tooltip.paused":    "Move more, drink more (paused until %v)",
+		"tray.quit":              "Quit",
+		"tray.quit.tip":          "Quit the program",
+		"tray.quit.denied":       "Quitting is not allowed (killing it is recorded and reported to your guardian)",
+		"tray.snooze":            "Snooze",
+		"tray.snooze.tip":        "Postpone this break reminder (limited times per day)",
+		"tray.pomodoro":          "Pomodoro",
+		"tray.pomodoro.tip":      "Focus/break cycles, check in during the break to start the next focus",
+		"tray.pomodoro.start":    "Start",
+		"tray.pomodoro.pause":    "Pause",
+		"tray.pomodoro.resume":   "Resume",
+		"tray.pomodoro.stop":     "Stop",
+		"pomodoro.tip.focus":     "Pomodoro: focus #%d, %v left",
+		"pomodoro.tip.break":     "Pomodoro: on break, next focus in %v",
+		"pomodoro.tip.scan":      "Pomodoro: on break (%v left), check in to start the next focus",
+		"pomodoro.tip.paused":    "Pomodoro: paused, %v left in this phase",
+		"pomodoro.running":       "Pomodoro is already running",
+		"pomodoro.not_running":   "Pomodoro is not running",
+		"pomodoro.paused":        "Pomodoro is already paused",
+		"pomodoro.not_paused":    "Pomodoro is not paused",
+		"pomodoro.bad_action":    "Unsupported pomodoro action: %s",
+		"snooze.not_due":         "It is not break time yet",
+		"snooze.bad_duration":    "Unsupported snooze duration: %v",
+		"snooze.exhausted":       "No snoozes left for today",
+		"snooze.action":          "Remind me in %v (%d left today)",
+		"pause.bad_duration":     "Pause duration must be between 1 minute and %v",
+		"pause.due":              "A reminder is due, finish it before pausing",
+		"pause.used_up":          "No pauses left for today",
+		"pause.not_paused":       "Not paused",
+		"msgbox.no_hint":         "Choose \"No\": %s",
+		"overlay.ok":             "Got it",
+		"unlock.hint.nfc":        "Scan the NFC tag to dismiss this reminder",
+		"unlock.hint.qr":         "Scan the QR code with your phone to dismiss this reminder",
+		"unlock.hint.phrase":     "Type the following phrase to dismiss this reminder: %s",
+		"unlock.hint.idle":       "Stay away from the computer for %v (no keyboard or mouse) to dismiss this reminder",
+		"unlock.action.phrase":   "Type phrase",
+		"unlock.phrase.defaults": "I have stood up and had some water|Sitting too long hurts, take a walk|Drink more water, sleep earlier",
+		"unlock.mismatch":        "This reminder cannot be dismissed this way",
+		"unlock.bad_tag":         "This tag does not belong to the reminder",
+		"unlock.bad_code":        "Invalid QR code",
+		"unlock.bad_phrase":      "The phrase does not match",
+		"unlock.no_target":       "No reminder can be dismissed this way",
+		"unlock.hint.sequence":   "Scan the tags in order: %s",
+		"unlock.same_tag":        "You cannot scan the same tag twice in a row, try another one",
+		"unlock.wrong_step":      "Wrong order, scan next: %s",
+		"unlock.progress":        "%s done, scan next: %s",
+		"unlock.progress.within": "%s done, scan %s within %v",
+		"unlock.not_away":        "You were not away from the computer long enough before scanning (need %v, got %v)",
+		"unlock.away_unknown":    "Computer input activity is unavailable (running as a service?), scans are refused while away.min_sec is set",
+		"tag.missing":            "No tag ID in the scan",
+		"tag.unknown":            "This tag is not registered",
+		"tag.revoked":            "This tag has been revoked",
+		"tag.bad_sig":            "Invalid tag signature, please rewrite the tag",
+		"tag.by_router":          "Tags are managed by the router, please operate there",
+		"tray.unlock.phrase":     "Type phrase to dismiss",
+		"tray.unlock.phrase.tip": "Type the phrase shown in the reminder to dismiss it",
+		"tray.guardian":          "Enter guardian code",
+		"tray.guardian.tip":      "Dismiss reminders with the 6-digit code read by your guardian when the router is unreachable",
+		"guardian.prompt":        "Enter the 6-digit code from your guardian's authenticator app:",
+		"guardian.disabled":      "Guardian code is not configured (guardian.totp_secret)",
+		"guardian.bad_code":      "The code is wrong or expired",
+		"guardian.reused":        "This code has already been used, wait for the next one",
+		"guardian.used_up":       "No guardian codes left for today",
+		"guardian.locked":        "Too many wrong codes, try again in %s",
+		"pair.code":              "Pairing code: %s (valid for %v), confirm it with your account:",
+		"pair.done":              "Paired: client %s, device %s",
+		"pair.expired":           "The pairing code expired, please pair again",
+		"pair.removed":           "Device credential removed",
+		"duration.sep":           " ",
+		"unit.hour.one":          "%d hour",
+		"unit.hour.other":        "%d hours",
+		"unit.minute.one":        "%d minute",
+		"unit.minute.other":      "%d minutes",
+		"unit.second.one":        "%d second",
+		"unit.second.other":      "%d seconds",
+	},
+}
+
+var (
+	langMutex   sync.RWMutex
+	currentLang = detectSystemLang()
+)
+
+// SetLanguage 设置界面语言，为空或auto时跟随系统
+func SetLanguage(lang string) {
+	if lang == "" || lang == LangAuto {
+		lang = detectSystemLang()
+	} else {
+		lang = matchLang(lang)
+	}
+
+	langMutex.Lock()
+	currentLang = lang
+	langMutex.Unlock()
+}
+
+func GetLanguage() string {
+	langMutex.RLock()
+	defer langMutex.RUnlock()
+	return currentLang
+}
+
+// T 按当前语言查找文案并格式化，缺失时回退到中文，再缺失时返回key本身
+func T(key string, args ...any) string {
+	format, ok := catalogs[GetLanguage()][key]
+	if !ok {
+		format, ok = catalogs[LangZhCN][key]
+	}
+	if !ok {
+		format = key
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// plural 根据数量选择单复数形式
+func plural(key string, count int) string {
+	form := ".other"
+	if count == 1 {
+		if _, ok := catalogs[GetLanguage()][key+".one"]; ok {
+			form = ".one"
+		}
+	}
+	return T(key+form, count)
+}
+
+// FormatDuration 将时长格式化为"1小时5分钟"/"1 hour 5 minutes"，不足一小时时才显示秒
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	seconds := int(d.Seconds()) % 60
+
+	var parts []string
+	if hours > 0 {
+		parts = append(parts, plural("unit.hour", hours))
+	}
+	if minutes > 0 {
+		parts = append(parts, plural("unit.minute", minutes))
+	}
+	if hours <= 0 && (seconds > 0 || minutes <= 0) {
+		parts = append(parts, plural("unit.second", seconds))
+	}
+	return strings.Join(parts, T("duration.sep"))
+}
+
+func matchLang(lang string) string {
+	if strings.HasPrefix(strings.ToLower(lang), "zh") {
+		return LangZhCN
+	}
+	return LangEn
+}
+
+func detectSystemLang() string {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	proc := kernel32.NewProc("GetUserDefaultLocaleName")
+	if proc.Find() != nil {
+		return LangZhCN
+	}
+
+	buf := make([]uint16, 85) // LOCALE_NAME_MAX_LENGTH
+	ret, _, _ := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if ret == 0 {
+		return LangZhCN
+	}
+	return matchLang(syscall.UTF16ToString(buf))
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Urgency int
+
+const (
+	UrgencyLow Urgency = iota
+	UrgencyNormal
+	UrgencyCritical
+)
+
+type NotificationResultType int
+
+const (
+	ResultDismissed  NotificationResultType = iota // 用户关闭或通知被撤回
+	ResultSnoozed                                  // 用户选择了稍后提醒
+	ResultAction                                   // 用户点击了其他动作按钮
+	ResultNoFeedback                               // sender无法回传用户操作(如系统通知)，不代表用户已确认
+)
+
+const ActionSnooze = "snooze"
+
+type NotificationAction struct {
+	Id    string // 回传给调用方的动作ID
+	Label string // 按钮文字
+	Url   string // 无法回传结果的sender(如系统通知)点击后打开该地址
+}
+
+type NotificationResult struct {
+	Type     NotificationResultType
+	ActionId string
+}
+
+type Notification struct {
+	Title   string
+	Message string
+	Urgency Urgency
+	Icon    string // 图标文件路径，为空时使用sender的默认图标
+	Actions []NotificationAction
+	Url     string // 无法回传结果的sender(如系统通知)点击通知本身时打开该地址
+
+	// Result 由sender在通知结束时写入一次结果，应使用带缓冲的channel
+	Result chan NotificationResult
+}
+
+func NewNotification(title, message string) *Notification {
+	return &Notification{
+		Title:   title,
+		Message: message,
+		Urgency: UrgencyNormal,
+		Result:  make(chan NotificationResult, 1),
+	}
+}
+
+func (n *Notification) AddAction(id, label, url string) *Notification {
+	n.Actions = append(n.Actions, NotificationAction{Id: id, Label: label, Url: url})
+	return n
+}
+
+// reply 写入通知结果，不会阻塞sender
+func (n *Notification) reply(resultType NotificationResultType, actionId string) {
+	if n.Result == nil {
+		return
+	}
+
+	select {
+	case n.Result <- NotificationResult{Type: resultType, ActionId: actionId}:
+	default:
+	}
+}
+
+// replyAction 按动作ID写入结果，稍后提醒动作单独归类
+func (n *Notification) replyAction(actionId string) {
+	if actionId == ActionSnooze {
+		n.reply(ResultSnoozed, actionId)
+	} else {
+		n.reply(ResultAction, actionId)
+	}
+}
+
+// ConsoleSender 将通知打印到标准输出，适合直接运行或调试时使用
+type ConsoleSender struct {
+	title string
+}
+
+func NewConsoleSender(title string) *ConsoleSender {
+	return &ConsoleSender{
+		title: title,
+	}
+}
+
+func (s *ConsoleSender) Show(n *Notification) {
+	title := n.Title
+	if title == "" {
+		title = s.title
+	}
+
+	mark := ""
+	if n.Urgency == UrgencyCritical {
+		mark = "!!! "
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("[%s] %s%s: %s\n", time.Now().Format("15:04:05"), mark, title, n.Message))
+	for _, action := range n.Actions {
+		if action.Url != "" {
+			sb.WriteString(fmt.Sprintf("    - %s: %s\n", action.Label, action.Url))
+		}
+	}
+	if n.Url != "" {
+		sb.WriteString(fmt.Sprintf("    - %s\n", n.Url))
+	}
+	fmt.Print(sb.String())
+
+	n.reply(ResultNoFeedback, "")
+}
+
+func (s *ConsoleSender) Close() {
+}
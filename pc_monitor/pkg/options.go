@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"os"
+	"path/filepath"
+)
+
+// Options 命令行的全局选项，在执行命令前由SetOptions设置
+type Options struct {
+	ConfigFile string   // 配置文件，默认为程序目录下的config.yaml
+	LogLevel   string   // 覆盖配置中的logging.level
+	DataDir    string   // 设备凭证、审计日志、状态文件及用户配置所在目录
+	Sets       []string // --set key.path=value，覆盖所有配置层
+	Args       []string // 命令自己的参数
+}
+
+var gOptions Options
+
+// SetOptions 设置全局选项，相对路径按当前目录转为绝对路径(以服务运行时当前目录不是程序目录)
+func SetOptions(opts Options) {
+	if opts.ConfigFile != "" {
+		opts.ConfigFile = absPath(opts.ConfigFile)
+	}
+	if opts.DataDir != "" {
+		opts.DataDir = absPath(opts.DataDir)
+	}
+	gOptions = opts
+
+	if opts.LogLevel != "" {
+		base.InitSimpleLogger("hn", opts.LogLevel)
+	}
+}
+
+// GlobalArgs 把已设置的全局选项还原为命令行参数，供守护进程启动监测程序时传递
+func GlobalArgs() []string {
+	var args []string
+	if gOptions.ConfigFile != "" {
+		args = append(args, "--config", gOptions.ConfigFile)
+	}
+	if gOptions.LogLevel != "" {
+		args = append(args, "--log-level", gOptions.LogLevel)
+	}
+	if gOptions.DataDir != "" {
+		args = append(args, "--data-dir", gOptions.DataDir)
+	}
+	for _, set := range gOptions.Sets {
+		args = append(args, "--set", set)
+	}
+	return args
+}
+
+// ConfigFilePath 系统配置文件路径
+func ConfigFilePath() string {
+	if gOptions.ConfigFile != "" {
+		return gOptions.ConfigFile
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		logger.Warnw("failed to get executable path", err)
+		return ConfigFileName
+	}
+	return filepath.Join(filepath.Dir(exePath), ConfigFileName)
+}
+
+// dataDir 设备凭证和审计日志默认保存在当前用户的配置目录中
+func dataDir() string {
+	if gOptions.DataDir != "" {
+		return gOptions.DataDir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, AppName)
+}
+
+// stateDir 状态文件默认保存在临时目录中，指定--data-dir时与审计日志放在一起
+func stateDir() string {
+	if gOptions.DataDir != "" {
+		return gOptions.DataDir
+	}
+	return os.TempDir()
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
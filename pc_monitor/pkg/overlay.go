@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"github.com/livekit/protocol/logger"
+	"github.com/lxn/walk"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+const (
+	GWL_EXSTYLE    = -20
+	WS_EX_LAYERED  = 0x00080000
+	WS_EX_TOPMOST  = 0x00000008
+	LWA_ALPHA      = 0x00000002
+	HWND_TOPMOST   = ^uintptr(0)
+	SWP_NOMOVE     = 0x0002
+	SWP_NOSIZE     = 0x0001
+	SWP_SHOWWINDOW = 0x0040
+)
+
+// OverlaySender 以半透明的全屏置顶窗口覆盖屏幕(屏幕变暗)，用于超时较久后的强提醒
+type OverlaySender struct {
+	title      string
+	alpha      byte
+	setLong    *syscall.LazyProc
+	setPos     *syscall.LazyProc
+	setLayered *syscall.LazyProc
+	mutex      sync.Mutex
+	window     *walk.MainWindow
+}
+
+func NewOverlaySender(title string, alpha byte) *OverlaySender {
+	user32 := syscall.NewLazyDLL("user32.dll")
+	if alpha == 0 {
+		alpha = 200
+	}
+
+	return &OverlaySender{
+		title:      title,
+		alpha:      alpha,
+		setLong:    user32.NewProc("SetWindowLongW"),
+		setPos:     user32.NewProc("SetWindowPos"),
+		setLayered: user32.NewProc("SetLayeredWindowAttributes"),
+	}
+}
+
+func (s *OverlaySender) Show(n *Notification) {
+	// walk的窗口消息循环必须在创建窗口的线程中运行
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	mw, err := walk.NewMainWindow()
+	if err != nil {
+		logger.Warnw("create overlay window failed", err)
+		n.reply(ResultNoFeedback, "")
+		return
+	}
+	defer mw.Dispose()
+
+	clicked := ""
+	if err = s.buildOverlay(mw, n, &clicked); err != nil {
+		logger.Warnw("build overlay window failed", err)
+		n.reply(ResultNoFeedback, "")
+		return
+	}
+
+	s.mutex.Lock()
+	s.window = mw
+	s.mutex.Unlock()
+
+	mw.Run()
+
+	s.mutex.Lock()
+	s.window = nil
+	s.mutex.Unlock()
+
+	if clicked != "" {
+		n.replyAction(clicked)
+	} else {
+		n.reply(ResultDismissed, "")
+	}
+}
+
+func (s *OverlaySender) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.window != nil {
+		mw := s.window
+		mw.Synchronize(func() {
+			mw.Close()
+		})
+	}
+}
+
+func (s *OverlaySender) buildOverlay(mw *walk.MainWindow, n *Notification, clicked *string) error {
+	title := n.Title
+	if title == "" {
+		title = s.title
+	}
+	_ = mw.SetTitle(title)
+	if err := mw.SetLayout(walk.NewVBoxLayout()); err != nil {
+		return err
+	}
+
+	background, err := walk.NewSolidColorBrush(walk.RGB(0, 0, 0))
+	if err != nil {
+		return err
+	}
+	mw.SetBackground(background)
+
+	label, err := walk.NewTextLabel(mw)
+	if err != nil {
+		return err
+	}
+	font, err := walk.NewFont("Microsoft YaHei", 28, walk.FontBold)
+	if err == nil {
+		label.SetFont(font)
+	}
+	label.SetTextColor(walk.RGB(255, 255, 255))
+	label.SetTextAlignment(walk.AlignHCenterVCenter)
+	if err = label.SetText(n.Message); err != nil {
+		return err
+	}
+
+	ok, err := walk.NewPushButton(mw)
+	if err != nil {
+		return err
+	}
+	_ = ok.SetText(T("overlay.ok"))
+	ok.Clicked().Attach(func() { mw.Close() })
+
+	for _, action := range n.Actions {
+		button, err := walk.NewPushButton(mw)
+		if err != nil {
+			return err
+		}
+		id := action.Id
+		_ = button.SetText(action.Label)
+		button.Clicked().Attach(func() {
+			*clicked = id
+			mw.Close()
+		})
+	}
+
+	if err = mw.SetFullscreen(true); err != nil {
+		return err
+	}
+
+	hwnd := uintptr(mw.Handle())
+	s.setLong.Call(hwnd, uintptr(GWL_EXSTYLE&0xffffffff), WS_EX_LAYERED|WS_EX_TOPMOST)
+	s.setLayered.Call(hwnd, 0, uintptr(s.alpha), LWA_ALPHA)
+	s.setPos.Call(hwnd, HWND_TOPMOST, 0, 0, 0, 0, SWP_NOMOVE|SWP_NOSIZE|SWP_SHOWWINDOW)
+	return nil
+}
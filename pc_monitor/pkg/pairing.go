@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"golang.org/x/sys/windows"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+const deviceFileName = "device.dat"
+
+// _DeviceCredential 与消息路由配对后得到的设备凭证，用DPAPI加密后保存
+type _DeviceCredential struct {
+	Router     string `json:"router"`
+	ClientId   string `json:"clientId"`
+	DeviceId   string `json:"deviceId"`
+	Credential string `json:"credential"`
+	TotpSecret string `json:"totpSecret"`
+}
+
+// applyDevice 已配对时以凭证中的客户端ID为准，并使用配对时生成的离线解锁码密钥
+func (r *HNReminder) applyDevice() {
+	device, res := loadDeviceCredential()
+	if !res.IsOk() {
+		logger.Warnw("load device credential failed", res)
+		return
+	}
+	if device == nil {
+		return
+	}
+
+	if r.config.ClientId != "" && r.config.ClientId != device.ClientId {
+		logger.Warnw("client_id differs from paired device, use the paired one", nil,
+			"config", r.config.ClientId, "device", device.ClientId)
+	}
+	r.config.ClientId = device.ClientId
+	if r.config.Guardian.TotpSecret == "" {
+		r.config.Guardian.TotpSecret = device.TotpSecret
+	}
+	r.device = device
+}
+
+// routerHeader 连接消息路由时携带设备凭证
+func (r *HNReminder) routerHeader() http.Header {
+	if r.device == nil {
+		return nil
+	}
+	return http.Header{"Authorization": {"Bearer " + r.device.Credential}}
+}
+
+// PairDevice 向消息路由申请配对码，等待账号确认后保存设备凭证
+func PairDevice(loadBuilding func()) {
+	base.InitDefaultLogger()
+
+	var config _Config
+	configFile := ConfigFilePath()
+	if res := loadConfig(configFile, &config); !res.IsOk() {
+		fmt.Println("load config failed:", res.Error())
+		return
+	}
+	if config.RouterUrl == "" {
+		fmt.Println("router_url is not configured")
+		return
+	}
+	tlsConfig, res := config.RouterTls.clientConfig(configFile)
+	if !res.IsOk() {
+		fmt.Println(res.Error())
+		return
+	}
+
+	start, res := routerApiUrl(config.RouterUrl, "/pair/start")
+	if !res.IsOk() {
+		fmt.Println(res.Error())
+		return
+	}
+	hostname, _ := os.Hostname()
+
+	var pairing struct {
+		Code      string `json:"code"`
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expiresIn"`
+	}
+	if res = callRouter(tlsConfig, http.MethodPost, start+"?"+url.Values{"name": {hostname}}.Encode(), nil, &pairing); !res.IsOk() {
+		fmt.Println("start pairing failed:", res.Error())
+		return
+	}
+
+	confirm, _ := routerApiUrl(config.RouterUrl, "/pair/confirm")
+	fmt.Println(T("pair.code", pairing.Code, FormatDuration(time.Duration(pairing.ExpiresIn)*time.Second)))
+	fmt.Printf("  curl -u <account> -X POST \"%s?code=%s\"\n", confirm, pairing.Code)
+
+	poll, _ := routerApiUrl(config.RouterUrl, "/pair/poll")
+	poll += "?" + url.Values{"token": {pairing.Token}}.Encode()
+	deadline := time.Now().Add(time.Duration(pairing.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+
+		var result struct {
+			Status string `json:"status"`
+			_DeviceCredential
+		}
+		if res = callRouter(tlsConfig, http.MethodGet, poll, nil, &result); !res.IsOk() {
+			fmt.Println("pairing failed:", res.Error())
+			return
+		}
+		if result.Status != "paired" {
+			continue
+		}
+
+		device := result._DeviceCredential
+		device.Router = config.RouterUrl
+		if res = saveDeviceCredential(&device); !res.IsOk() {
+			fmt.Println("save device credential failed:", res.Error())
+			return
+		}
+		fmt.Println(T("pair.done", device.ClientId, device.DeviceId))
+		return
+	}
+	fmt.Println(T("pair.expired"))
+}
+
+// UnpairDevice 删除本机保存的设备凭证，之后需重新配对
+func UnpairDevice(loadBuilding func()) {
+	if err := os.Remove(deviceFilePath()); err != nil && !os.IsNotExist(err) {
+		fmt.Println("remove device credential failed:", err)
+		return
+	}
+	fmt.Println(T("pair.removed"))
+}
+
+// callRouter 调用消息路由的http接口，data非空时解析返回的data字段
+func callRouter(tlsConfig *tls.Config, method, api string, header http.Header, data any) base.Result {
+	req, err := http.NewRequest(method, api, nil)
+	if err != nil {
+		return base.INVALID_PARAM.AppendErr("invalid router url", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rsp, err := routerHttpClient(tlsConfig).Do(req)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("request router failed", err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("read router response failed", err)
+	}
+	var result struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return base.INTERNAL_ERROR.SetMsg(fmt.Sprintf("unexpected router response (%d): %s", rsp.StatusCode, body))
+	}
+	if result.Code != base.SUCCESS.Code() {
+		return base.NewResult(result.Code, result.Message, nil)
+	}
+
+	if data != nil {
+		if err = json.Unmarshal(result.Data, data); err != nil {
+			return base.INTERNAL_ERROR.AppendErr("parse router response failed", err)
+		}
+	}
+	return base.SUCCESS
+}
+
+// deviceFilePath 设备凭证默认保存在当前用户的配置目录中，只有该用户能解密
+func deviceFilePath() string {
+	return filepath.Join(dataDir(), deviceFileName)
+}
+
+func loadDeviceCredential() (*_DeviceCredential, base.Result) {
+	data, err := os.ReadFile(deviceFilePath())
+	if os.IsNotExist(err) {
+		return nil, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read device credential failed", err)
+	}
+
+	plain, err := dpapiDecrypt(data)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("decrypt device credential failed", err)
+	}
+	device := &_DeviceCredential{}
+	if err = json.Unmarshal(plain, device); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse device credential failed", err)
+	}
+	return device, base.SUCCESS
+}
+
+func saveDeviceCredential(device *_DeviceCredential) base.Result {
+	plain, err := json.Marshal(device)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal device credential failed", err)
+	}
+	data, err := dpapiEncrypt(plain)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("encrypt device credential failed", err)
+	}
+
+	file := deviceFilePath()
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("create credential directory failed", err)
+	}
+	if err = os.WriteFile(file, data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write device credential failed", err)
+	}
+	return base.SUCCESS
+}
+
+func dpapiEncrypt(plain []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newDataBlob(plain), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func dpapiDecrypt(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newDataBlob(data), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob 复制DPAPI分配的内存并释放
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"time"
+)
+
+type _PauseConfig struct {
+	MaxMin     int `yaml:"max_min"`     // 单次暂停的最长分钟数，默认120
+	DailyLimit int `yaml:"daily_limit"` // 每天最多可暂停的次数，默认2
+}
+
+func (c *_PauseConfig) fillDefaults() {
+	if c.MaxMin <= 0 {
+		c.MaxMin = 120
+	}
+	if c.DailyLimit <= 0 {
+		c.DailyLimit = 2
+	}
+}
+
+// _PauseState 暂停计时的截止时间及当日已暂停的次数
+type _PauseState struct {
+	Until time.Time `json:"until"` // 零值表示未暂停
+	Day   string    `json:"day"`   // 计数所属日期(2006-01-02)
+	Count int       `json:"count"`
+}
+
+// PauseStatus 暂停状态
+type PauseStatus struct {
+	Paused    bool      `json:"paused"`
+	Until     time.Time `json:"until,omitempty"`
+	Remaining int       `json:"remaining"` // 今日剩余的暂停次数
+}
+
+// Pause 暂停所有提醒的计时(如开会、外出)，已有提醒到期时不能暂停，到期后自动恢复并重新开始计时
+func (r *HNReminder) Pause(minutes int) base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if minutes <= 0 || minutes > r.config.Pause.MaxMin {
+		return base.INVALID_PARAM.SetMsg(T("pause.bad_duration", FormatDuration(time.Duration(r.config.Pause.MaxMin)*time.Minute)))
+	}
+	for _, rem := range r.reminders {
+		if rem.shouldRemind {
+			return base.ACTION_ILLEGAL.SetMsg(T("pause.due"))
+		}
+	}
+
+	now := time.Now()
+	state := r.rollPauseDay(now)
+	if state.Count >= r.config.Pause.DailyLimit {
+		return base.ACTION_ILLEGAL.SetMsg(T("pause.used_up"))
+	}
+
+	state.Count++
+	state.Until = now.Add(time.Duration(minutes) * time.Minute)
+	r.store.save()
+	r.closeReminder()
+
+	logger.Infow("HydrateNow: paused", "until", state.Until, "count", state.Count)
+	r.audit(AuditPause, "user", "", fmt.Sprintf("%d min", minutes))
+	return base.SUCCESS.SetData(gin.H{"until": state.Until, "remaining": r.config.Pause.DailyLimit - state.Count})
+}
+
+// Resume 提前结束暂停
+func (r *HNReminder) Resume() base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.store.data.Pause.Until.IsZero() {
+		return base.ACTION_ILLEGAL.SetMsg(T("pause.not_paused"))
+	}
+	r.endPause(time.Now(), "user")
+	return base.SUCCESS
+}
+
+// checkPause 暂停期间返回true，暂停到期时恢复，调用方需持有锁
+func (r *HNReminder) checkPause(now time.Time) (paused bool) {
+	until := r.store.data.Pause.Until
+	if until.IsZero() {
+		return false
+	}
+	if now.Before(until) {
+		return true
+	}
+	r.endPause(now, "expired")
+	return false
+}
+
+// endPause 结束暂停，所有提醒从现在开始新的工作周期，调用方需持有锁
+func (r *HNReminder) endPause(now time.Time, by string) {
+	r.store.data.Pause.Until = time.Time{}
+	for _, rem := range r.reminders {
+		rem.state.LastBreakTime = now
+		rem.state.SnoozeUntil = time.Time{}
+	}
+	r.store.save()
+
+	logger.Infow("HydrateNow: resumed", "by", by)
+	r.audit(AuditResume, by, "", "")
+}
+
+func (r *HNReminder) rollPauseDay(now time.Time) *_PauseState {
+	state := &r.store.data.Pause
+	if day := now.Format("2006-01-02"); state.Day != day {
+		state.Day = day
+		state.Count = 0
+	}
+	return state
+}
+
+// GetPauseStatus 返回暂停状态及今日剩余的暂停次数
+func (r *HNReminder) GetPauseStatus() PauseStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	state := r.rollPauseDay(now)
+	status := PauseStatus{Remaining: r.config.Pause.DailyLimit - state.Count}
+	if !state.Until.IsZero() && now.Before(state.Until) {
+		status.Paused = true
+		status.Until = state.Until
+	}
+	return status
+}
@@ -0,0 +1,296 @@
+package pkg
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	PhaseIdle       = "idle"
+	PhaseFocus      = "focus"
+	PhaseShortBreak = "short_break"
+	PhaseLongBreak  = "long_break"
+)
+
+type _PomodoroConfig struct {
+	FocusMin       int    `yaml:"focus_min"`        // 专注时长(分钟)，默认25
+	ShortBreakMin  int    `yaml:"short_break_min"`  // 短休息时长(分钟)，默认5
+	LongBreakMin   int    `yaml:"long_break_min"`   // 长休息时长(分钟)，默认15
+	LongBreakEvery int    `yaml:"long_break_every"` // 每完成N个专注进行一次长休息，默认4
+	Reminder       string `yaml:"reminder"`         // 休息阶段触发的提醒，默认water
+	AutoStart      bool   `yaml:"auto_start"`       // 启动时自动开始番茄钟
+}
+
+// _PomodoroState 番茄钟状态，保存在状态存储中
+type _PomodoroState struct {
+	Phase     string    `json:"phase"`
+	PhaseEnd  time.Time `json:"phaseEnd"` // 当前阶段的结束时间，暂停时无效
+	Paused    bool      `json:"paused"`
+	LeftSec   int       `json:"leftSec"`   // 暂停时当前阶段剩余的秒数
+	Completed int       `json:"completed"` // 已完成的专注次数
+	Scanned   bool      `json:"scanned"`   // 休息阶段是否已打卡，打卡后休息结束才能开始下个专注
+}
+
+// PomodoroStatus 番茄钟对外展示的状态
+type PomodoroStatus struct {
+	Phase     string `json:"phase"`
+	Paused    bool   `json:"paused"`
+	LeftSec   int    `json:"leftSec"`   // 当前阶段剩余的秒数
+	Completed int    `json:"completed"` // 已完成的专注次数
+	Scanned   bool   `json:"scanned"`   // 休息阶段是否已打卡
+}
+
+func (c *_PomodoroConfig) fillDefaults() {
+	if c.FocusMin <= 0 {
+		c.FocusMin = 25
+	}
+	if c.ShortBreakMin <= 0 {
+		c.ShortBreakMin = 5
+	}
+	if c.LongBreakMin <= 0 {
+		c.LongBreakMin = 15
+	}
+	if c.LongBreakEvery <= 0 {
+		c.LongBreakEvery = 4
+	}
+	if c.Reminder == "" {
+		c.Reminder = defaultReminderName
+	}
+}
+
+func (r *HNReminder) initPomodoro() base.Result {
+	rems, res := r.findReminders(r.config.Pomodoro.Reminder, nil)
+	if !res.IsOk() {
+		return base.INVALID_PARAM.SetMsg("pomodoro reminder not found: " + r.config.Pomodoro.Reminder)
+	}
+	r.pomodoroReminder = rems[0]
+
+	state := &r.store.data.Pomodoro
+	if state.Phase == "" {
+		state.Phase = PhaseIdle
+	}
+	if state.Phase == PhaseIdle && r.config.Pomodoro.AutoStart {
+		r.startFocus(time.Now())
+		r.store.save()
+	}
+	return base.SUCCESS
+}
+
+// drivenByPomodoro 番茄钟运行时，其休息提醒由番茄钟阶段触发而不按间隔计时
+func (r *HNReminder) drivenByPomodoro(rem *_Reminder) bool {
+	return rem == r.pomodoroReminder && r.store.data.Pomodoro.Phase != PhaseIdle
+}
+
+// checkPomodoro 推进番茄钟阶段，调用方需持有锁
+func (r *HNReminder) checkPomodoro(now time.Time) {
+	state := &r.store.data.Pomodoro
+	if state.Phase == PhaseIdle || state.Paused || now.Before(state.PhaseEnd) {
+		return
+	}
+
+	switch state.Phase {
+	case PhaseFocus:
+		state.Completed++
+		breakMin := r.config.Pomodoro.ShortBreakMin
+		state.Phase = PhaseShortBreak
+		if state.Completed%r.config.Pomodoro.LongBreakEvery == 0 {
+			breakMin = r.config.Pomodoro.LongBreakMin
+			state.Phase = PhaseLongBreak
+		}
+		state.PhaseEnd = now.Add(time.Duration(breakMin) * time.Minute)
+		state.Scanned = false
+
+		rem := r.pomodoroReminder
+		rem.state.SnoozeUntil = time.Time{}
+		rem.shouldRemind = true
+		rem.remindSince = now
+		logger.Infow("HydrateNow: pomodoro break", "phase", state.Phase, "completed", state.Completed)
+		r.store.save()
+	case PhaseShortBreak, PhaseLongBreak:
+		// 未打卡时停留在休息阶段，继续提醒
+		if state.Scanned {
+			r.startFocus(now)
+			r.store.save()
+		}
+	}
+}
+
+// onPomodoroBreakDone 休息提醒被完成时记录打卡，调用方需持有锁
+func (r *HNReminder) onPomodoroBreakDone(rem *_Reminder) {
+	state := &r.store.data.Pomodoro
+	if rem != r.pomodoroReminder || (state.Phase != PhaseShortBreak && state.Phase != PhaseLongBreak) {
+		return
+	}
+
+	logger.Infow("HydrateNow: pomodoro break done", "phase", state.Phase)
+	state.Scanned = true
+	r.checkPomodoro(time.Now())
+}
+
+func (r *HNReminder) startFocus(now time.Time) {
+	state := &r.store.data.Pomodoro
+	state.Phase = PhaseFocus
+	state.PhaseEnd = now.Add(time.Duration(r.config.Pomodoro.FocusMin) * time.Minute)
+	state.Paused = false
+	state.Scanned = false
+	logger.Infow("HydrateNow: pomodoro focus", "completed", state.Completed)
+}
+
+// StartPomodoro 从专注阶段开始新一轮番茄钟
+func (r *HNReminder) StartPomodoro() base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state := &r.store.data.Pomodoro
+	if state.Phase != PhaseIdle {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.running"))
+	}
+
+	now := time.Now()
+	state.Completed = 0
+	r.startFocus(now)
+	rem := r.pomodoroReminder
+	rem.shouldRemind = false
+	rem.state.SnoozeUntil = time.Time{}
+	r.store.save()
+	if r.shown == rem {
+		r.closeReminder()
+	}
+	return base.SUCCESS.SetData(r.pomodoroStatus(now))
+}
+
+// PausePomodoro 暂停当前阶段的计时，暂停期间不提醒休息
+func (r *HNReminder) PausePomodoro() base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state := &r.store.data.Pomodoro
+	if state.Phase == PhaseIdle {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.not_running"))
+	}
+	if state.Paused {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.paused"))
+	}
+
+	now := time.Now()
+	state.Paused = true
+	state.LeftSec = int(state.PhaseEnd.Sub(now).Seconds())
+	if state.LeftSec < 0 {
+		state.LeftSec = 0
+	}
+	r.pomodoroReminder.shouldRemind = false
+	r.store.save()
+	if r.shown == r.pomodoroReminder {
+		r.closeReminder()
+	}
+
+	logger.Infow("HydrateNow: pomodoro paused", "phase", state.Phase, "leftSec", state.LeftSec)
+	return base.SUCCESS.SetData(r.pomodoroStatus(now))
+}
+
+// ResumePomodoro 继续暂停的阶段，休息阶段未打卡时恢复提醒
+func (r *HNReminder) ResumePomodoro() base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state := &r.store.data.Pomodoro
+	if state.Phase == PhaseIdle {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.not_running"))
+	}
+	if !state.Paused {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.not_paused"))
+	}
+
+	now := time.Now()
+	state.Paused = false
+	state.PhaseEnd = now.Add(time.Duration(state.LeftSec) * time.Second)
+	state.LeftSec = 0
+	if state.Phase != PhaseFocus && !state.Scanned {
+		rem := r.pomodoroReminder
+		rem.shouldRemind = true
+		rem.remindSince = now
+	}
+	r.store.save()
+
+	logger.Infow("HydrateNow: pomodoro resumed", "phase", state.Phase)
+	return base.SUCCESS.SetData(r.pomodoroStatus(now))
+}
+
+// StopPomodoro 结束番茄钟，休息提醒恢复按间隔计时
+func (r *HNReminder) StopPomodoro() base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state := &r.store.data.Pomodoro
+	if state.Phase == PhaseIdle {
+		return base.ACTION_ILLEGAL.SetMsg(T("pomodoro.not_running"))
+	}
+
+	now := time.Now()
+	*state = _PomodoroState{Phase: PhaseIdle}
+	rem := r.pomodoroReminder
+	rem.shouldRemind = false
+	rem.state.LastBreakTime = now
+	r.store.save()
+	if r.shown == rem {
+		r.closeReminder()
+	}
+
+	logger.Infow("HydrateNow: pomodoro stopped")
+	return base.SUCCESS.SetData(r.pomodoroStatus(now))
+}
+
+func (r *HNReminder) GetPomodoroStatus() PomodoroStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.pomodoroStatus(time.Now())
+}
+
+func (r *HNReminder) pomodoroStatus(now time.Time) PomodoroStatus {
+	state := &r.store.data.Pomodoro
+	status := PomodoroStatus{
+		Phase:     state.Phase,
+		Paused:    state.Paused,
+		Completed: state.Completed,
+		Scanned:   state.Scanned,
+	}
+
+	if state.Paused {
+		status.LeftSec = state.LeftSec
+	} else if state.Phase != PhaseIdle && now.Before(state.PhaseEnd) {
+		status.LeftSec = int(state.PhaseEnd.Sub(now).Seconds())
+	}
+	return status
+}
+
+// onReqPomodoroHandler POST /pomodoro?action=start|pause|resume|stop，不带action时返回状态
+func (r *HNReminder) onReqPomodoroHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	var res base.Result
+	switch action := c.Query("action"); action {
+	case "":
+		res = base.SUCCESS.SetData(r.GetPomodoroStatus())
+	case "start":
+		res = r.StartPomodoro()
+	case "pause":
+		res = r.PausePomodoro()
+	case "resume":
+		res = r.ResumePomodoro()
+	case "stop":
+		res = r.StopPomodoro()
+	default:
+		res = base.INVALID_PARAM.SetMsg(T("pomodoro.bad_action", action))
+	}
+
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
@@ -1,15 +1,15 @@
 package pkg
 
 import (
+	"crypto/tls"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/livekit/protocol/logger"
 	"github.com/patstar123/go-base"
 	bu "github.com/patstar123/go-base/utils"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
@@ -24,20 +24,30 @@ type HNReminder struct {
 	http      *gin.Engine
 	running   bool
 	msgSender MessageSender
-
-	mutex          sync.Mutex
-	lastBreakTime  time.Time
-	lastRemindTime time.Time
-	shouldRemind   bool
-	shownRemind    bool
+	activity  ActivitySource
+	away      *AwayTracker
+	device    *_DeviceCredential // 与消息路由配对后的设备凭证，未配对时为nil
+	routerTls *tls.Config
+	auditLog  *AuditLog
+	lastBeat  time.Time // 上次心跳，带单调时间用于检测系统时间被调整
+
+	mutex            sync.Mutex
+	reminders        []*_Reminder
+	shown            *_Reminder // 正在展示的提醒，同一时间只展示一个
+	pomodoroReminder *_Reminder
+	store            *_Store
+	content          *ContentProvider
+	schedule         *Schedule
+	offDuty          bool
+	calendar         *Calendar
+	deferredUntil    time.Time
+	deferredBy       string
 }
 
 var (
 	gReminder = &HNReminder{
-		running:      false,
-		mutex:        sync.Mutex{},
-		shouldRemind: false,
-		shownRemind:  false,
+		running: false,
+		mutex:   sync.Mutex{},
 	}
 )
 
@@ -50,6 +60,7 @@ func (r *HNReminder) Init(configFile string, external *ServiceLogger, msgSender
 	if !res.IsOk() {
 		return res
 	}
+	SetLanguage(r.config.Language)
 
 	// 从配置初始化全局PkgLogger
 	base.InitLogger("hn", &r.config.Logging)
@@ -61,20 +72,51 @@ func (r *HNReminder) Init(configFile string, external *ServiceLogger, msgSender
 		}
 	}
 
-	logger.Infow("loadConfigFile", "config", r.config, "file", configFile)
+	// 日志中不输出密钥
+	view, _ := configView(&r.config)
+	logger.Infow("loadConfigFile", "config", view, "file", configFile)
+
+	if r.auditLog, res = OpenAuditLog(auditFilePath()); !res.IsOk() {
+		logger.Warnw("open audit log failed, unlocks are not audited", res)
+	}
+
+	if res = r.initContent(configFile); !res.IsOk() {
+		return res
+	}
+
+	if r.schedule, res = NewSchedule(&r.config.Schedule); !res.IsOk() {
+		return res
+	}
+	r.calendar = NewCalendar(&r.config.Calendar, configFile)
+	if r.routerTls, res = r.config.RouterTls.clientConfig(configFile); !res.IsOk() {
+		return res
+	}
 
 	r.initHttp()
 	r.msgSender = msgSender
+	if len(r.config.Escalation) > 0 {
+		if res = r.initEscalation(msgSender); !res.IsOk() {
+			return res
+		}
+	}
 
-	r.shouldRemind = false
-	lastBreakTime := getLastBreakTimeFromTemp()
-	if lastBreakTime != nil {
-		r.lastBreakTime = *lastBreakTime
-	} else {
-		r.resetLastBreakTime()
+	r.activity = NewLastInputSource()
+	if r.config.Away.MinSec > 0 {
+		r.away = NewAwayTracker(r.activity, time.Duration(r.config.Away.MinSec)*time.Second)
+		if _, ok := r.activity.IdleDuration(); !ok {
+			logger.Warnw("away.min_sec is set but input activity is unavailable, tag and code scans will be rejected", nil)
+		}
+	}
+	if res = r.initReminders(); !res.IsOk() {
+		return res
 	}
+	if res = r.initPomodoro(); !res.IsOk() {
+		return res
+	}
+	r.checkDowntime(time.Now())
+	r.checkAuditReset()
 
-	logger.Infow("init successfully", "lastBreakTime", r.lastBreakTime)
+	logger.Infow("init successfully", "state", r.store.data)
 	return base.SUCCESS
 }
 
@@ -84,6 +126,11 @@ func (r *HNReminder) Run() base.Result {
 
 	go r.remindingCheckLoop()
 	go r.connect2Router()
+	go r.tagSyncLoop()
+	go r.heartbeatLoop()
+	if r.calendar != nil {
+		go r.calendar.refreshLoop(func() bool { return r.running })
+	}
 
 	logger.Infow("run in http loop")
 	err := r.http.Run(":" + r.config.ApiPort)
@@ -105,21 +152,44 @@ func (r *HNReminder) Release() base.Result {
 	return base.SUCCESS
 }
 
+// GetStatus 有提醒到期时返回距离下次重复提醒的时长，否则返回距离最近一个提醒到期的时长
 func (r *HNReminder) GetStatus() (shouldRemind bool, nextDuration time.Duration) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	now := time.Now()
-	if r.shouldRemind {
-		return true, r.lastRemindTime.Add(time.Duration(r.config.AlwaysRemindIntervalSec) * time.Second).Sub(now)
-	} else {
-		return false, r.lastBreakTime.Add(time.Duration(r.config.BreakIntervalSec) * time.Second).Sub(now)
+	var next time.Time
+	for _, rem := range r.reminders {
+		if rem.shouldRemind {
+			remindAt := rem.lastRemindTime.Add(time.Duration(r.config.AlwaysRemindIntervalSec) * time.Second)
+			if !shouldRemind || remindAt.Before(next) {
+				next = remindAt
+			}
+			shouldRemind = true
+		} else if !shouldRemind {
+			if due := r.nextDue(rem); next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
 	}
+	return shouldRemind, next.Sub(now)
 }
 
 func (r *HNReminder) initHttp() {
 	r.http = bu.CreateGinHttp(nil)
+	// 只有解除提醒需要从局域网访问(手机扫码)，其余只接受本机访问
 	r.http.Any("/reset_remind", r.onReqResetRemindHandler)
+	r.http.Any("/status", r.onReqStatusHandler)
+	r.http.POST("/pomodoro", localOnly, r.onReqPomodoroHandler)
+	// 系统通知的按钮在浏览器中以GET打开地址
+	r.http.GET("/snooze", localOnly, r.onReqSnoozeHandler)
+	r.http.POST("/snooze", localOnly, r.onReqSnoozeHandler)
+	r.http.GET("/unlock_prompt", localOnly, r.onReqUnlockPromptHandler)
+	r.http.POST("/unlock_prompt", localOnly, r.onReqUnlockPromptHandler)
+	r.http.Any("/ack", localOnly, r.onReqAckHandler)
+	r.http.GET("/tags", localOnly, r.onReqTagsHandler)
+	r.http.POST("/tags/:action", localOnly, r.onReqTagActionHandler)
+	r.initApi()
 }
 
 func (r *HNReminder) connect2Router() {
@@ -129,7 +199,7 @@ func (r *HNReminder) connect2Router() {
 	}
 
 	logger.Infow("Connecting to router: " + r.config.RouterUrl)
-	c, _, err := websocket.DefaultDialer.Dial(r.config.RouterUrl, nil)
+	c, _, err := routerDialer(r.routerTls).Dial(r.config.RouterUrl, r.routerHeader())
 	if err != nil {
 		logger.Warnw("ws dial failed", err)
 		r.delay2ReconnectRouter()
@@ -153,22 +223,32 @@ func (r *HNReminder) connect2Router() {
 		}
 
 		logger.Debugw("ws received: " + string(message))
-		if string(message) == "reset_remind" {
-			err = c.WriteMessage(websocket.TextMessage, []byte("Good boy"))
+		// 消息路由会把扫描地址上的参数(如tag/code/name)附在命令后面
+		if cmd, rawQuery, _ := strings.Cut(string(message), "?"); cmd == "reset_remind" {
+			query, _ := url.ParseQuery(rawQuery)
+			proof := proofFromQuery(query)
+			proof.Routed = true
+			res := r.resetRemind(query.Get("name"), proof)
+			err = c.WriteMessage(websocket.TextMessage, routerReply(res))
 			if err != nil {
 				logger.Warnw("ws write rsp failed", err)
 				r.delay2ReconnectRouter()
 				return
 			}
-			r.resetRemind()
 		}
 	}
 }
 
 func (r *HNReminder) onReqResetRemindHandler(c *gin.Context) {
-	bu.LogHttpRequest(nil)
-	bu.ReturnRsp(c, http.StatusOK, "Good boy")
-	r.resetRemind()
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	query := c.Request.URL.Query()
+	res := r.resetRemind(query.Get("name"), proofFromQuery(query))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, unlockReply(res))
 }
 
 func (r *HNReminder) delay2ReconnectRouter() {
@@ -178,49 +258,72 @@ func (r *HNReminder) delay2ReconnectRouter() {
 	}()
 }
 
-func (r *HNReminder) resetRemind() {
-	logger.Infow("HydrateNow: good boy")
-
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
-	r.shouldRemind = false
-	r.resetLastBreakTime()
-
-	if r.shownRemind {
-		go r.closeReminder()
-	}
-}
-
 type _Config struct {
 	BreakIntervalSec        int    `yaml:"break_interval_sec"`
 	AlwaysRemindIntervalSec int    `yaml:"always_remind_interval_sec"`
 	ApiPort                 string `yaml:"api_port"`
-
-	ClientId  string `yaml:"client_id"`
-	RouterUrl string `yaml:"router_url"`
+	Language                string `yaml:"language"`
+
+	ClientId  string           `yaml:"client_id"`
+	RouterUrl string           `yaml:"router_url"`
+	RouterTls _RouterTlsConfig `yaml:"router_tls"`
+
+	Reminders  []_ReminderDef    `yaml:"reminders"`
+	Snooze     _SnoozeConfig     `yaml:"snooze"`
+	Pomodoro   _PomodoroConfig   `yaml:"pomodoro"`
+	Escalation []_EscalationStep `yaml:"escalation"`
+	Content    _ContentConfig    `yaml:"content"`
+	Schedule   _ScheduleConfig   `yaml:"schedule"`
+	Calendar   _CalendarConfig   `yaml:"calendar"`
+	Away       _AwayConfig       `yaml:"away"`
+	Guardian   _GuardianConfig   `yaml:"guardian"`
+	Heartbeat  _HeartbeatConfig  `yaml:"heartbeat"`
+	Pause      _PauseConfig      `yaml:"pause"`
 
 	Logging logger.Config `yaml:"logging,omitempty" json:"-"`
 }
 
-func (r *HNReminder) loadConfigFile(configFile string) base.Result {
-	res := bu.GetConfig(configFile, &r.config)
-	if !res.IsOk() {
-		return res
+func (c *_Config) fillDefaults() {
+	if c.BreakIntervalSec <= 0 {
+		c.BreakIntervalSec = 1 * 60 * 60
+	}
+
+	if c.AlwaysRemindIntervalSec <= 0 {
+		c.AlwaysRemindIntervalSec = 5
+	}
+
+	if c.ApiPort == "" {
+		c.ApiPort = "18081"
 	}
 
-	if r.config.BreakIntervalSec <= 0 {
-		r.config.BreakIntervalSec = 1 * 60 * 60
+	c.Snooze.fillDefaults()
+	c.Pomodoro.fillDefaults()
+	c.Guardian.fillDefaults()
+	c.Heartbeat.fillDefaults()
+	c.Pause.fillDefaults()
+
+	if c.Calendar.RefreshSec <= 0 {
+		c.Calendar.RefreshSec = 15 * 60
 	}
 
-	if r.config.AlwaysRemindIntervalSec <= 0 {
-		r.config.AlwaysRemindIntervalSec = 5
+	if c.Calendar.GraceSec == nil {
+		graceSec := 5 * 60
+		c.Calendar.GraceSec = &graceSec
 	}
 
-	if r.config.ApiPort == "" {
-		r.config.ApiPort = "18081"
+	if c.Away.GraceSec <= 0 {
+		c.Away.GraceSec = 60
 	}
+}
 
+func (r *HNReminder) loadConfigFile(configFile string) base.Result {
+	res := loadConfig(configFile, &r.config)
+	if !res.IsOk() {
+		return res
+	}
+	r.config.fillDefaults()
+
+	r.applyDevice()
 	if r.config.ClientId == "" {
 		logger.Warnw("not config client_id", nil)
 		return base.INVALID_PARAM
@@ -250,84 +353,92 @@ func (r *HNReminder) checkReminder() {
 	defer r.mutex.Unlock()
 
 	now := time.Now()
-	if r.shouldRemind {
-		//logger.Warnw("HydrateNow: shouldRemind", nil)
-		if now.Sub(r.lastRemindTime) > time.Duration(r.config.AlwaysRemindIntervalSec)*time.Second {
-			//logger.Warnw("HydrateNow: showReminder", nil)
-			r.showReminder()
-		}
-	} else {
-		//logger.Warnw("HydrateNow: check BreakTime", nil)
-		if now.Sub(r.lastBreakTime) > time.Duration(r.config.BreakIntervalSec)*time.Second {
-			logger.Infow("HydrateNow: break time")
-			r.shouldRemind = true
-			r.showReminder()
-		}
+	if r.away != nil {
+		r.away.Sample(now)
 	}
-}
-
-const message = "你已经工作了一段时间，请站起来去喝水。"
 
-func (r *HNReminder) showReminder() {
-	if r.shownRemind {
+	if r.checkPause(now) || !r.checkSchedule(now) {
 		return
 	}
+	r.checkPomodoro(now)
 
-	r.shownRemind = true
-	go func() {
-		r.msgSender.Show(message)
-
-		r.mutex.Lock()
-		defer r.mutex.Unlock()
-		r.lastRemindTime = time.Now()
-		r.shownRemind = false
-	}()
-}
+	due := false
+	for _, rem := range r.reminders {
+		if r.isSnoozing(rem, now) {
+			continue
+		}
 
-func (r *HNReminder) closeReminder() {
-	if r.shownRemind {
-		r.msgSender.Close()
+		if !rem.shouldRemind && !r.drivenByPomodoro(rem) && now.Sub(rem.state.LastBreakTime) > time.Duration(r.currentBreakIntervalSec(rem))*time.Second {
+			logger.Infow("HydrateNow: break time", "reminder", rem.def.Name)
+			rem.shouldRemind = true
+			rem.remindSince = now
+		}
+		due = due || rem.shouldRemind
 	}
-}
 
-func (r *HNReminder) resetLastBreakTime() {
-	r.lastBreakTime = time.Now()
-	saveLastBreakTimeToTemp(&r.lastBreakTime)
-}
+	if !due {
+		return
+	}
+	r.pollUnlockTasks(now)
+	if r.checkCalendar(now) {
+		return
+	}
 
-func getLastBreakTimeFromTemp() *time.Time {
-	lastFile := filepath.Join(os.TempDir(), "hydrate_now.last_break")
-	if _, err := os.Stat(lastFile); os.IsNotExist(err) {
-		return nil
+	for _, rem := range r.reminders {
+		if rem.shouldRemind && now.Sub(rem.lastRemindTime) > time.Duration(r.config.AlwaysRemindIntervalSec)*time.Second {
+			r.showReminder(rem)
+		}
 	}
+}
 
-	content, err := ioutil.ReadFile(lastFile)
-	if err != nil {
-		logger.Warnw("failed to read last break time from temp file", err)
-		return nil
+// showReminder 展示提醒，已有提醒在展示时忽略，调用方需持有锁
+func (r *HNReminder) showReminder(rem *_Reminder) {
+	if r.shown != nil {
+		return
 	}
 
-	lastTime := &time.Time{}
-	err = lastTime.UnmarshalText(content)
-	if err != nil {
-		logger.Warnw("failed to UnmarshalText for last break time", err)
-		return nil
+	r.shown = rem
+	rem.closing = false
+	now := time.Now()
+	message := r.nextMessage(rem, now)
+	if hint := r.taskHintOf(rem, now); hint != "" {
+		message += "\n" + hint
 	}
+	n := NewNotification(AppName, message)
+	n.Icon = getIconFilePath()
+	r.addUnlockAction(n, rem)
+	r.addSnoozeAction(n, rem)
+	go func() {
+		r.msgSender.Show(n)
 
-	return lastTime
+		r.mutex.Lock()
+		rem.lastRemindTime = time.Now()
+		r.shown = nil
+		closing := rem.closing
+		r.mutex.Unlock()
+
+		result := <-n.Result
+		switch {
+		case result.Type == ResultSnoozed:
+			if res := r.Snooze(rem.def.Name, 0); !res.IsOk() {
+				logger.Warnw("snooze from notification failed", res)
+			}
+		case result.Type == ResultAction && result.ActionId == ActionUnlock:
+			if res := r.PromptUnlock(rem.def.Name); !res.IsOk() {
+				logger.Warnw("prompt unlock from notification failed", res)
+			}
+		case result.Type == ResultDismissed && !closing:
+			r.ackRemind(rem)
+		}
+	}()
 }
 
-func saveLastBreakTimeToTemp(time *time.Time) {
-	content, err := time.MarshalText()
-	if err != nil {
-		logger.Warnw("failed to MarshalText for last break time", err)
+// closeReminder 关闭正在展示的提醒，调用方需持有锁
+func (r *HNReminder) closeReminder() {
+	if r.shown == nil {
 		return
 	}
 
-	lastFile := filepath.Join(os.TempDir(), "hydrate_now.last_break")
-	err = ioutil.WriteFile(lastFile, content, 0644)
-	if err != nil {
-		logger.Warnw("failed to update last break time to temp file", err)
-		return
-	}
+	r.shown.closing = true
+	go r.msgSender.Close()
 }
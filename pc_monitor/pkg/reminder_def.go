@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"net/http"
+	"time"
+)
+
+const defaultReminderName = "water"
+
+type _ReminderDef struct {
+	Name        string            `yaml:"name"`           // 唯一名称，如water/eye/stand
+	IntervalSec int               `yaml:"interval_sec"`   // 提醒间隔，默认为break_interval_sec
+	Unlock      string            `yaml:"unlock"`         // 解除提醒的任务: nfc(默认)、qr、phrase、idle、ack
+	UnlockOpts  _UnlockConfig     `yaml:"unlock_options"` // 解除任务的参数
+	Message     string            `yaml:"message"`        // 固定的提醒内容模板，为空时从内容文件中轮换
+	Messages    map[string]string `yaml:"messages"`       // 各语言的提醒内容模板，优先于message
+	Category    string            `yaml:"category"`       // 从内容文件中轮换时只使用该分类的内容
+}
+
+// _Reminder 一种提醒的定义及运行时状态，所有字段由HNReminder的锁保护
+type _Reminder struct {
+	def     _ReminderDef
+	state   *_ReminderState
+	content *ContentProvider
+	task    UnlockTask
+
+	lastRemindTime time.Time
+	remindSince    time.Time
+	shouldRemind   bool
+	closing        bool   // 提醒是被程序关闭的(而非用户确认)
+	taskHint       string // 本次提醒的任务说明，任务完成后清空
+}
+
+func (r *HNReminder) initReminders() base.Result {
+	defs := r.config.Reminders
+	if len(defs) == 0 {
+		defs = []_ReminderDef{{Name: defaultReminderName}}
+	}
+
+	r.store = loadStore()
+	r.migrateTagSecrets()
+	states := r.store.data.Reminders
+	r.store.data.Reminders = map[string]*_ReminderState{}
+	r.reminders = nil
+
+	now := time.Now()
+	for _, def := range defs {
+		if def.Name == "" {
+			return base.INVALID_PARAM.SetMsg("reminder name is required")
+		}
+		if _, ok := r.store.data.Reminders[def.Name]; ok {
+			return base.INVALID_PARAM.SetMsg("duplicated reminder: " + def.Name)
+		}
+
+		if def.IntervalSec <= 0 {
+			def.IntervalSec = r.config.BreakIntervalSec
+		}
+
+		// 未在配置中保留的提醒，其状态随之丢弃
+		state, ok := states[def.Name]
+		if !ok {
+			state = &_ReminderState{LastBreakTime: now}
+		}
+		r.store.data.Reminders[def.Name] = state
+		rollBreaksDay(state, now)
+
+		rem := &_Reminder{def: def, state: state}
+		var res base.Result
+		if rem.task, res = newUnlockTask(def.Unlock, &def.UnlockOpts, state, r.activity); !res.IsOk() {
+			return res.AppendMsg("reminder " + def.Name)
+		}
+
+		if def.Message != "" || len(def.Messages) > 0 {
+			rem.content, res = NewContentProvider([]_Tip{{Category: def.Name, Text: def.Message, Texts: def.Messages}}, 0)
+			if !res.IsOk() {
+				return res
+			}
+		} else {
+			rem.content = r.content.WithCategory(def.Category)
+		}
+
+		r.reminders = append(r.reminders, rem)
+	}
+
+	r.rollSnoozeDay(now)
+	r.store.save()
+	return base.SUCCESS
+}
+
+// ReminderStatus 提醒对外展示的状态
+type ReminderStatus struct {
+	Name         string `json:"name"`
+	Unlock       string `json:"unlock"`
+	ShouldRemind bool   `json:"shouldRemind"`
+	NextSec      int    `json:"nextSec"`    // 未到期时为距离到期的秒数
+	OverdueSec   int    `json:"overdueSec"` // 已到期时为已超时的秒数
+	BreaksToday  int    `json:"breaksToday"`
+	Progress     string `json:"progress,omitempty"` // 多步任务的完成进度，如1/2
+}
+
+// GetReminderStatuses 返回所有提醒的状态
+func (r *HNReminder) GetReminderStatuses() []ReminderStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	var statuses []ReminderStatus
+	for _, rem := range r.reminders {
+		rollBreaksDay(rem.state, now)
+		status := ReminderStatus{
+			Name:         rem.def.Name,
+			Unlock:       rem.task.Kind(),
+			ShouldRemind: rem.shouldRemind,
+			BreaksToday:  rem.state.BreaksToday,
+		}
+		if !rem.shouldRemind && !r.drivenByPomodoro(rem) {
+			status.NextSec = int(r.nextDue(rem).Sub(now).Seconds())
+		}
+		if rem.shouldRemind {
+			status.OverdueSec = int(now.Sub(rem.remindSince).Seconds())
+		}
+		if reporter, ok := rem.task.(_ProgressReporter); ok {
+			status.Progress = reporter.Progress(now)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (r *HNReminder) onReqStatusHandler(c *gin.Context) {
+	bu.LogHttpRequest(nil)
+	bu.ReturnRsp(c, http.StatusOK, base.SUCCESS.SetData(r.GetReminderStatuses()))
+}
+
+// findReminders 按名称查找提醒，name为空时返回满足filter的全部提醒，调用方需持有锁
+func (r *HNReminder) findReminders(name string, filter func(rem *_Reminder) bool) ([]*_Reminder, base.Result) {
+	var found []*_Reminder
+	for _, rem := range r.reminders {
+		if name != "" && rem.def.Name != name {
+			continue
+		}
+		if filter == nil || filter(rem) {
+			found = append(found, rem)
+		}
+		if name != "" {
+			return found, base.SUCCESS
+		}
+	}
+
+	if name != "" {
+		logger.Warnw("unknown reminder", nil, "name", name)
+		return nil, base.INVALID_PARAM.SetMsg(T("reminder.unknown", name))
+	}
+	return found, base.SUCCESS
+}
+
+// nextDue 返回提醒下次到期的时间
+func (r *HNReminder) nextDue(rem *_Reminder) time.Time {
+	if !rem.state.SnoozeUntil.IsZero() {
+		return rem.state.SnoozeUntil
+	}
+	return rem.state.LastBreakTime.Add(time.Duration(r.currentBreakIntervalSec(rem)) * time.Second)
+}
+
+func (r *HNReminder) countBreak(rem *_Reminder, now time.Time) {
+	rollBreaksDay(rem.state, now)
+	rem.state.BreaksToday++
+}
+
+func rollBreaksDay(state *_ReminderState, now time.Time) {
+	day := now.Format("2006-01-02")
+	if state.BreaksDay != day {
+		state.BreaksDay = day
+		state.BreaksToday = 0
+	}
+}
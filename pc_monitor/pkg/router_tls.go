@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/gorilla/websocket"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type _RouterTlsConfig struct {
+	CaFile     string `yaml:"ca_file"`     // 信任的CA证书(PEM)，配置后只信任该CA，使用路由的自签名证书时即该证书本身
+	ServerName string `yaml:"server_name"` // 校验证书时使用的域名，与router_url中的主机不一致时配置
+}
+
+// clientConfig 连接wss/https路由时使用的TLS配置，未配置CA时使用系统信任的证书
+func (c *_RouterTlsConfig) clientConfig(configFile string) (*tls.Config, base.Result) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.ServerName}
+	if c.CaFile == "" {
+		return tlsConfig, base.SUCCESS
+	}
+
+	caFile := c.CaFile
+	if !filepath.IsAbs(caFile) {
+		caFile = filepath.Join(filepath.Dir(configFile), caFile)
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("read router ca_file failed", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, base.INVALID_PARAM.SetMsg("no certificate found in router ca_file")
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, base.SUCCESS
+}
+
+func routerDialer(tlsConfig *tls.Config) *websocket.Dialer {
+	return &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  tlsConfig,
+	}
+}
+
+func routerHttpClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+}
@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"strings"
+	"time"
+	_ "time/tzdata" // Windows上没有系统时区数据库，需要内嵌
+)
+
+var weekdayKeys = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type _ScheduleConfig struct {
+	Timezone string              `yaml:"timezone"` // 时区，如Asia/Shanghai，为空时使用系统时区
+	Days     map[string][]string `yaml:"days"`     // 每天的工作时段，key为mon~sun，值如["09:00-12:00", "13:30-18:00"]
+	Holidays []string            `yaml:"holidays"` // 不工作的日期(2006-01-02)
+}
+
+type _TimeWindow struct {
+	start int // 当天的起始分钟
+	end   int // 当天的结束分钟(不含)
+}
+
+// Schedule 每周的工作时段，工作时段之外暂停计时
+type Schedule struct {
+	loc      *time.Location
+	days     [7][]_TimeWindow
+	holidays map[string]bool
+}
+
+// NewSchedule 未配置任何工作时段时返回nil，表示全天工作
+func NewSchedule(cfg *_ScheduleConfig) (*Schedule, base.Result) {
+	if len(cfg.Days) == 0 {
+		return nil, base.SUCCESS
+	}
+
+	s := &Schedule{
+		loc:      time.Local,
+		holidays: map[string]bool{},
+	}
+
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, base.INVALID_PARAM.AppendErr("invalid schedule timezone", err)
+		}
+		s.loc = loc
+	}
+
+	for key, windows := range cfg.Days {
+		weekday, ok := weekdayKeys[strings.ToLower(key)]
+		if !ok {
+			return nil, base.INVALID_PARAM.SetMsg("invalid schedule day: " + key)
+		}
+
+		for _, str := range windows {
+			w, err := parseTimeWindow(str)
+			if err != nil {
+				return nil, base.INVALID_PARAM.AppendErr("invalid schedule window", err)
+			}
+			s.days[weekday] = append(s.days[weekday], w)
+		}
+	}
+
+	for _, day := range cfg.Holidays {
+		if _, err := time.ParseInLocation("2006-01-02", day, s.loc); err != nil {
+			return nil, base.INVALID_PARAM.AppendErr("invalid holiday", err)
+		}
+		s.holidays[day] = true
+	}
+
+	return s, base.SUCCESS
+}
+
+// WindowAt 若t处于工作时段内，返回该时段的起止时间
+func (s *Schedule) WindowAt(t time.Time) (start, end time.Time, ok bool) {
+	t = t.In(s.loc)
+	if s.holidays[t.Format("2006-01-02")] {
+		return time.Time{}, time.Time{}, false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+	for _, w := range s.days[t.Weekday()] {
+		if minute >= w.start && minute < w.end {
+			return midnight.Add(time.Duration(w.start) * time.Minute), midnight.Add(time.Duration(w.end) * time.Minute), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// NextStart 返回t之后最近的工作时段开始时间，两周内没有工作时段时返回零值
+func (s *Schedule) NextStart(t time.Time) time.Time {
+	t = t.In(s.loc)
+	for i := 0; i < 14; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 0, 0, 0, 0, s.loc)
+		if s.holidays[day.Format("2006-01-02")] {
+			continue
+		}
+
+		for _, w := range s.days[day.Weekday()] {
+			start := day.Add(time.Duration(w.start) * time.Minute)
+			if start.After(t) {
+				return start
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// WorkingBetween 返回[from, to)中处于工作时段的时长
+func (s *Schedule) WorkingBetween(from, to time.Time) time.Duration {
+	from, to = from.In(s.loc), to.In(s.loc)
+	total := time.Duration(0)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, s.loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if s.holidays[day.Format("2006-01-02")] {
+			continue
+		}
+
+		for _, w := range s.days[day.Weekday()] {
+			start := day.Add(time.Duration(w.start) * time.Minute)
+			end := day.Add(time.Duration(w.end) * time.Minute)
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if end.After(start) {
+				total += end.Sub(start)
+			}
+		}
+	}
+	return total
+}
+
+func parseTimeWindow(str string) (_TimeWindow, error) {
+	parts := strings.Split(str, "-")
+	if len(parts) != 2 {
+		return _TimeWindow{}, fmt.Errorf("window should be like 09:00-12:00: %s", str)
+	}
+
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return _TimeWindow{}, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return _TimeWindow{}, err
+	}
+	if end <= start {
+		return _TimeWindow{}, fmt.Errorf("window end should be after start: %s", str)
+	}
+
+	return _TimeWindow{start: start, end: end}, nil
+}
+
+func parseClock(str string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(strings.TrimSpace(str), "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("invalid clock %s: %v", str, err)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid clock %s", str)
+	}
+	return hour*60 + minute, nil
+}
+
+// checkSchedule 工作时段之外暂停计时，进入新的工作时段时重新开始计时，调用方需持有锁
+func (r *HNReminder) checkSchedule(now time.Time) (working bool) {
+	if r.schedule == nil {
+		return true
+	}
+
+	start, _, ok := r.schedule.WindowAt(now)
+	if !ok {
+		if !r.offDuty {
+			logger.Infow("HydrateNow: off duty")
+			r.offDuty = true
+			for _, rem := range r.reminders {
+				rem.shouldRemind = false
+			}
+			r.closeReminder()
+		}
+		return false
+	}
+
+	r.offDuty = false
+	started := false
+	for _, rem := range r.reminders {
+		if rem.state.LastBreakTime.Before(start) {
+			rem.shouldRemind = false
+			rem.state.LastBreakTime = start
+			rem.state.SnoozeUntil = time.Time{}
+			started = true
+		}
+	}
+	if started {
+		logger.Infow("HydrateNow: working window started", "start", start)
+		r.store.save()
+	}
+	return true
+}
+
+// GetOffDuty 返回当前是否处于非工作时段，以及下个工作时段的开始时间
+func (r *HNReminder) GetOffDuty() (offDuty bool, nextStart time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.offDuty {
+		return false, time.Time{}
+	}
+	return true, r.schedule.NextStart(time.Now())
+}
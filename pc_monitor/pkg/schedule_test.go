@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	cases := []struct {
+		str   string
+		start int
+		end   int
+		ok    bool
+	}{
+		{"09:00-12:00", 9 * 60, 12 * 60, true},
+		{" 13:30 - 18:05 ", 13*60 + 30, 18*60 + 5, true},
+		{"00:00-24:00", 0, 24 * 60, true},
+		{"09:00", 0, 0, false},
+		{"12:00-09:00", 0, 0, false},
+		{"09:00-09:00", 0, 0, false},
+		{"09:60-10:00", 0, 0, false},
+		{"23:00-24:01", 0, 0, false},
+		{"9am-5pm", 0, 0, false},
+	}
+	for _, c := range cases {
+		w, err := parseTimeWindow(c.str)
+		if (err == nil) != c.ok {
+			t.Errorf("parseTimeWindow(%q) err = %v, want ok %v", c.str, err, c.ok)
+			continue
+		}
+		if c.ok && (w.start != c.start || w.end != c.end) {
+			t.Errorf("parseTimeWindow(%q) = %d-%d, want %d-%d", c.str, w.start, w.end, c.start, c.end)
+		}
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  _ScheduleConfig
+	}{
+		{"bad day", _ScheduleConfig{Days: map[string][]string{"monday": {"09:00-12:00"}}}},
+		{"bad window", _ScheduleConfig{Days: map[string][]string{"mon": {"09:00-08:00"}}}},
+		{"bad timezone", _ScheduleConfig{Timezone: "Mars/Olympus", Days: map[string][]string{"mon": {"09:00-12:00"}}}},
+		{"bad holiday", _ScheduleConfig{Days: map[string][]string{"mon": {"09:00-12:00"}}, Holidays: []string{"2024/05/01"}}},
+	}
+	for _, c := range cases {
+		if _, res := NewSchedule(&c.cfg); res.IsOk() {
+			t.Errorf("%s: NewSchedule succeeded, want error", c.name)
+		}
+	}
+
+	if s, res := NewSchedule(&_ScheduleConfig{}); !res.IsOk() || s != nil {
+		t.Errorf("empty schedule = %v, %s; want nil (always working)", s, res.Message())
+	}
+}
+
+func testSchedule(t *testing.T) (*Schedule, *time.Location) {
+	s, res := NewSchedule(&_ScheduleConfig{
+		Timezone: "Asia/Shanghai",
+		Days: map[string][]string{
+			"Mon": {"09:00-12:00", "13:30-18:00"},
+			"tue": {"09:00-12:00", "13:30-18:00"},
+			"fri": {"09:00-12:00"},
+		},
+		Holidays: []string{"2024-05-07"}, // 周二
+	})
+	if !res.IsOk() {
+		t.Fatalf("NewSchedule: %s", res.Message())
+	}
+	return s, s.loc
+}
+
+func TestScheduleWindowAt(t *testing.T) {
+	s, loc := testSchedule(t)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, loc) }
+
+	cases := []struct {
+		name  string
+		t     time.Time
+		ok    bool
+		start time.Time
+		end   time.Time
+	}{
+		{"morning", at(6, 10, 0), true, at(6, 9, 0), at(6, 12, 0)},
+		{"window start is inclusive", at(6, 9, 0), true, at(6, 9, 0), at(6, 12, 0)},
+		{"window end is exclusive", at(6, 12, 0), false, time.Time{}, time.Time{}},
+		{"lunch", at(6, 12, 30), false, time.Time{}, time.Time{}},
+		{"afternoon", at(6, 17, 59), true, at(6, 13, 30), at(6, 18, 0)},
+		{"holiday", at(7, 10, 0), false, time.Time{}, time.Time{}},
+		{"unconfigured day", at(8, 10, 0), false, time.Time{}, time.Time{}},
+		// 按配置的时区判断：UTC 01:30 是上海 09:30
+		{"other timezone", time.Date(2024, 5, 6, 1, 30, 0, 0, time.UTC), true, at(6, 9, 0), at(6, 12, 0)},
+	}
+	for _, c := range cases {
+		start, end, ok := s.WindowAt(c.t)
+		if ok != c.ok || !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%s: WindowAt(%s) = %s, %s, %v; want %s, %s, %v", c.name, c.t, start, end, ok, c.start, c.end, c.ok)
+		}
+	}
+}
+
+func TestScheduleNextStart(t *testing.T) {
+	s, loc := testSchedule(t)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, loc) }
+
+	cases := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"before work", at(6, 8, 0), at(6, 9, 0)},
+		{"lunch", at(6, 12, 0), at(6, 13, 30)},
+		{"in a window", at(6, 9, 0), at(6, 13, 30)},
+		{"skips holiday", at(6, 18, 0), at(10, 9, 0)},
+		{"next week", at(10, 12, 0), at(13, 9, 0)},
+	}
+	for _, c := range cases {
+		if got := s.NextStart(c.t); !got.Equal(c.want) {
+			t.Errorf("%s: NextStart(%s) = %s, want %s", c.name, c.t, got, c.want)
+		}
+	}
+
+	empty := &Schedule{loc: loc, holidays: map[string]bool{}}
+	if got := empty.NextStart(at(6, 8, 0)); !got.IsZero() {
+		t.Errorf("NextStart without windows = %s, want zero", got)
+	}
+}
+
+func TestScheduleWorkingBetween(t *testing.T) {
+	s, loc := testSchedule(t)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, loc) }
+
+	cases := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want time.Duration
+	}{
+		{"inside one window", at(6, 10, 0), at(6, 11, 0), time.Hour},
+		{"across lunch", at(6, 11, 0), at(6, 14, 0), 90 * time.Minute},
+		{"whole day", at(6, 0, 0), at(7, 0, 0), 7*time.Hour + 30*time.Minute},
+		{"holiday and off days", at(7, 0, 0), at(10, 0, 0), 0},
+		{"over a week", at(6, 0, 0), at(13, 0, 0), 7*time.Hour + 30*time.Minute + 3*time.Hour},
+		{"empty range", at(6, 10, 0), at(6, 10, 0), 0},
+	}
+	for _, c := range cases {
+		if got := s.WorkingBetween(c.from, c.to); got != c.want {
+			t.Errorf("%s: WorkingBetween = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -23,7 +23,7 @@ func RunService(loadBuilding func()) {
 
 	sender := NewNotificationSender(AppName)
 
-	configFile := getConfigFilePath()
+	configFile := ConfigFilePath()
 	if true {
 		if res := reminder.Init(configFile, nil, sender); !res.IsOk() {
 			logger.Warnw("reminder init with error", res)
@@ -124,6 +124,7 @@ var status2Str = map[service.Status]string{
 
 func createService() service.Service {
 	prg := &program{}
+	svcConfig.Arguments = GlobalArgs() // 安装时带的全局选项在服务启动时同样生效
 	s, err := service.New(prg, svcConfig)
 	if err != nil {
 		logger.Warnw("create service failed", err)
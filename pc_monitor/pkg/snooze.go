@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type _SnoozeConfig struct {
+	DurationsMin        []int `yaml:"durations_min"`          // 可选的稍后提醒时长(分钟)，第一个为默认值
+	DailyLimit          int   `yaml:"daily_limit"`            // 每天最多可稍后提醒的次数(所有提醒共享)
+	PenaltySec          int   `yaml:"penalty_sec"`            // 第N次稍后提醒会让被推迟提醒的下个工作周期缩短N*penalty_sec秒
+	MinBreakIntervalSec int   `yaml:"min_break_interval_sec"` // 惩罚后工作周期的下限，超过提醒间隔时取提醒间隔的一半
+}
+
+func (c *_SnoozeConfig) fillDefaults() {
+	if len(c.DurationsMin) == 0 {
+		c.DurationsMin = []int{5, 10, 15}
+	}
+
+	if c.DailyLimit <= 0 {
+		c.DailyLimit = 3
+	}
+
+	if c.PenaltySec < 0 {
+		c.PenaltySec = 0
+	}
+}
+
+func (c *_SnoozeConfig) isValidDuration(minutes int) bool {
+	for _, m := range c.DurationsMin {
+		if m == minutes {
+			return true
+		}
+	}
+	return false
+}
+
+// Snooze 推迟已到期的提醒，name为空时推迟所有已到期的提醒，minutes为0时使用默认时长
+func (r *HNReminder) Snooze(name string, minutes int) base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	targets, res := r.findReminders(name, func(rem *_Reminder) bool { return rem.shouldRemind })
+	if !res.IsOk() {
+		return res
+	}
+	if len(targets) == 0 {
+		return base.ACTION_ILLEGAL.SetMsg(T("snooze.not_due"))
+	}
+
+	if minutes == 0 {
+		minutes = r.config.Snooze.DurationsMin[0]
+	} else if !r.config.Snooze.isValidDuration(minutes) {
+		return base.INVALID_PARAM.SetMsg(T("snooze.bad_duration", FormatDuration(time.Duration(minutes)*time.Minute)))
+	}
+
+	now := time.Now()
+	r.rollSnoozeDay(now)
+	budget := &r.store.data.Snooze
+	if budget.Count >= r.config.Snooze.DailyLimit {
+		return base.ACTION_ILLEGAL.SetMsg(T("snooze.exhausted"))
+	}
+
+	budget.Count++
+	until := now.Add(time.Duration(minutes) * time.Minute)
+	var names []string
+	for _, rem := range targets {
+		rem.state.PenaltySec += budget.Count * r.config.Snooze.PenaltySec
+		rem.state.SnoozeUntil = until
+		rem.shouldRemind = false
+		names = append(names, rem.def.Name)
+	}
+	r.store.save()
+
+	logger.Infow("HydrateNow: snoozed", "reminders", names, "minutes", minutes, "count", budget.Count)
+	r.audit(AuditSnooze, "user", strings.Join(names, ","), fmt.Sprintf("%d min", minutes))
+
+	if r.shown != nil && !r.shown.shouldRemind {
+		r.closeReminder()
+	}
+
+	return base.SUCCESS.SetData(gin.H{
+		"until":     until,
+		"remaining": r.config.Snooze.DailyLimit - budget.Count,
+		"reminders": names,
+	})
+}
+
+// GetSnoozeStatus 返回今日剩余的稍后提醒次数及可选时长
+func (r *HNReminder) GetSnoozeStatus() (remaining int, durationsMin []int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.rollSnoozeDay(time.Now())
+	return r.config.Snooze.DailyLimit - r.store.data.Snooze.Count, r.config.Snooze.DurationsMin
+}
+
+func (r *HNReminder) onReqSnoozeHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	minutes := 0
+	if str := c.Query("min"); str != "" {
+		var err error
+		minutes, err = strconv.Atoi(str)
+		if err != nil {
+			bu.ReturnRsp(c, http.StatusBadRequest, base.INVALID_PARAM.AppendErr("invalid min", err))
+			return
+		}
+	}
+
+	res := r.Snooze(c.Query("name"), minutes)
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+// addSnoozeAction 今日还有剩余次数时，为通知添加默认时长的稍后提醒按钮
+func (r *HNReminder) addSnoozeAction(n *Notification, rem *_Reminder) {
+	r.rollSnoozeDay(time.Now())
+	remaining := r.config.Snooze.DailyLimit - r.store.data.Snooze.Count
+	if remaining <= 0 {
+		return
+	}
+
+	minutes := r.config.Snooze.DurationsMin[0]
+	n.AddAction(ActionSnooze,
+		T("snooze.action", FormatDuration(time.Duration(minutes)*time.Minute), remaining),
+		fmt.Sprintf("http://127.0.0.1:%s/snooze?min=%d&name=%s", r.config.ApiPort, minutes, url.QueryEscape(rem.def.Name)))
+}
+
+// isSnoozing 检查提醒的稍后提醒是否仍在生效，到期后恢复提醒
+func (r *HNReminder) isSnoozing(rem *_Reminder, now time.Time) bool {
+	if rem.state.SnoozeUntil.IsZero() {
+		return false
+	}
+
+	if now.Before(rem.state.SnoozeUntil) {
+		return true
+	}
+
+	logger.Infow("HydrateNow: snooze expired", "reminder", rem.def.Name)
+	rem.state.SnoozeUntil = time.Time{}
+	rem.shouldRemind = true
+	rem.remindSince = now
+	r.store.save()
+	return false
+}
+
+func (r *HNReminder) rollSnoozeDay(now time.Time) {
+	day := now.Format("2006-01-02")
+	budget := &r.store.data.Snooze
+	if budget.Day != day {
+		budget.Day = day
+		budget.Count = 0
+	}
+}
+
+// applySnoozePenalty 打卡后将累积的惩罚作用到该提醒的下个工作周期
+func (r *HNReminder) applySnoozePenalty(rem *_Reminder) {
+	state := rem.state
+	state.SnoozeUntil = time.Time{}
+	if state.PenaltySec > 0 {
+		minInterval := r.config.Snooze.MinBreakIntervalSec
+		if minInterval <= 0 || minInterval > rem.def.IntervalSec {
+			minInterval = rem.def.IntervalSec / 2
+		}
+
+		interval := rem.def.IntervalSec - state.PenaltySec
+		if interval < minInterval {
+			interval = minInterval
+		}
+		state.BreakIntervalSec = interval
+		state.PenaltySec = 0
+	} else {
+		state.BreakIntervalSec = 0
+	}
+}
+
+// currentBreakIntervalSec 提醒当前的工作周期时长(可能已被稍后提醒惩罚缩短)
+func (r *HNReminder) currentBreakIntervalSec(rem *_Reminder) int {
+	if rem.state.BreakIntervalSec > 0 {
+		return rem.state.BreakIntervalSec
+	}
+	return rem.def.IntervalSec
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"github.com/livekit/protocol/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	storeFile           = "hydrate_now.state"
+	legacyLastBreakFile = "hydrate_now.last_break"
+)
+
+// _ReminderState 单个提醒需要持久化的状态
+type _ReminderState struct {
+	LastBreakTime    time.Time `json:"lastBreakTime"`
+	SnoozeUntil      time.Time `json:"snoozeUntil"`      // 稍后提醒的截止时间，零值表示未处于稍后提醒中
+	PenaltySec       int       `json:"penaltySec"`       // 累积的惩罚，打卡后作用于下个工作周期
+	BreakIntervalSec int       `json:"breakIntervalSec"` // 当前工作周期时长，0表示使用配置值
+	BreaksDay        string    `json:"breaksDay"`        // 打卡计数所属日期(2006-01-02)
+	BreaksToday      int       `json:"breaksToday"`      // 当日已完成的次数
+	LastTag          string    `json:"lastTag"`          // 上次解除提醒所用的标签
+}
+
+// _SnoozeBudget 所有提醒共享的每日稍后提醒次数
+type _SnoozeBudget struct {
+	Day   string `json:"day"`   // 计数所属日期(2006-01-02)
+	Count int    `json:"count"` // 当日已使用次数
+}
+
+type _StoreData struct {
+	Reminders map[string]*_ReminderState `json:"reminders"`
+	Snooze    _SnoozeBudget              `json:"snooze"`
+	Pomodoro  _PomodoroState             `json:"pomodoro"`
+	Guardian  _GuardianState             `json:"guardian"`
+	Heartbeat _HeartbeatState            `json:"heartbeat"`
+	Pause     _PauseState                `json:"pause"`
+	Tags      map[string]*TagInfo        `json:"tags"` // 标签登记表，连接消息路由时为路由登记表的副本
+}
+
+// _Store 所有提醒共享的状态存储，保存在临时目录中以便重启后恢复
+type _Store struct {
+	data _StoreData
+}
+
+func loadStore() *_Store {
+	s := &_Store{}
+	if !loadJsonFromTemp(storeFile, &s.data) {
+		s.migrateLegacy()
+	}
+	if s.data.Reminders == nil {
+		s.data.Reminders = map[string]*_ReminderState{}
+	}
+	if s.data.Tags == nil {
+		s.data.Tags = map[string]*TagInfo{}
+	}
+	return s
+}
+
+// reminder 返回指定提醒的状态，不存在时创建
+func (s *_Store) reminder(name string) *_ReminderState {
+	state, ok := s.data.Reminders[name]
+	if !ok {
+		state = &_ReminderState{}
+		s.data.Reminders[name] = state
+	}
+	return state
+}
+
+func (s *_Store) save() {
+	saveJsonToTemp(storeFile, &s.data)
+}
+
+// migrateLegacy 从旧版本保存的上次休息时间文件中恢复默认提醒的状态
+func (s *_Store) migrateLegacy() {
+	s.data.Reminders = map[string]*_ReminderState{}
+
+	lastBreakTime := getLastBreakTimeFromTemp()
+	if lastBreakTime == nil {
+		return
+	}
+
+	state := s.reminder(defaultReminderName)
+	state.LastBreakTime = *lastBreakTime
+	logger.Infow("migrated legacy state", "state", state)
+}
+
+func getLastBreakTimeFromTemp() *time.Time {
+	lastFile := filepath.Join(stateDir(), legacyLastBreakFile)
+	if _, err := os.Stat(lastFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(lastFile)
+	if err != nil {
+		logger.Warnw("failed to read last break time from temp file", err)
+		return nil
+	}
+
+	lastTime := &time.Time{}
+	err = lastTime.UnmarshalText(content)
+	if err != nil {
+		logger.Warnw("failed to UnmarshalText for last break time", err)
+		return nil
+	}
+
+	return lastTime
+}
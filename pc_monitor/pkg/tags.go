@@ -0,0 +1,317 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const tagSyncInterval = 5 * time.Minute
+
+// TagInfo 一个已登记的标签，本地只保存签名的哈希，不保存密钥
+type TagInfo struct {
+	Id         string    `json:"id"`
+	Location   string    `json:"location"`
+	SigHash    string    `json:"sigHash"`
+	Secret     string    `json:"secret,omitempty"` // 旧版本保存的密钥，加载后转换为sigHash
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastScanAt time.Time `json:"lastScanAt"`
+}
+
+// ListTags 返回本地登记的标签(按ID排序)，不包含签名哈希
+func (r *HNReminder) ListTags() []TagInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	list := []TagInfo{}
+	for _, tag := range r.store.data.Tags {
+		info := *tag
+		info.SigHash = ""
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// CreateTag 用tag_maker生成的密钥登记一个新标签，仅独立模式可用，连接消息路由时以路由的登记表为准
+func (r *HNReminder) CreateTag(location, secret string) (TagInfo, base.Result) {
+	if res := r.checkTagsLocal(); !res.IsOk() {
+		return TagInfo{}, res
+	}
+	if location == "" {
+		return TagInfo{}, base.INVALID_PARAM.SetMsg("location is required")
+	}
+	if res := checkTagSecret(secret); !res.IsOk() {
+		return TagInfo{}, res
+	}
+
+	suffix, err := randomHex(3)
+	if err != nil {
+		return TagInfo{}, base.INTERNAL_ERROR.AppendErr("generate tag id failed", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tag := &TagInfo{
+		Id:        location + "-" + suffix,
+		Location:  location,
+		CreatedAt: time.Now(),
+	}
+	tag.SigHash = tagSigHash(r.config.ClientId, tag.Id, signTag(secret, r.config.ClientId, tag.Id))
+	r.store.data.Tags[tag.Id] = tag
+	r.store.save()
+
+	info := *tag
+	info.SigHash = ""
+	return info, base.SUCCESS
+}
+
+// LabelTag 修改标签的位置名称
+func (r *HNReminder) LabelTag(tagId, location string) base.Result {
+	if location == "" {
+		return base.INVALID_PARAM.SetMsg("location is required")
+	}
+	return r.updateTag(tagId, func(tag *TagInfo) { tag.Location = location })
+}
+
+// RevokeTag 作废丢失或不再使用的标签
+func (r *HNReminder) RevokeTag(tagId string) base.Result {
+	return r.updateTag(tagId, func(tag *TagInfo) { tag.Revoked = true })
+}
+
+// RotateTagSecret 换用tag_maker生成的新密钥，需要用新地址重新写入标签
+func (r *HNReminder) RotateTagSecret(tagId, secret string) (TagInfo, base.Result) {
+	if res := checkTagSecret(secret); !res.IsOk() {
+		return TagInfo{}, res
+	}
+
+	var info TagInfo
+	res := r.updateTag(tagId, func(tag *TagInfo) {
+		tag.SigHash = tagSigHash(r.config.ClientId, tagId, signTag(secret, r.config.ClientId, tagId))
+		info = *tag
+		info.SigHash = ""
+	})
+	return info, res
+}
+
+func (r *HNReminder) updateTag(tagId string, fn func(tag *TagInfo)) base.Result {
+	if res := r.checkTagsLocal(); !res.IsOk() {
+		return res
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tag, ok := r.store.data.Tags[tagId]
+	if !ok {
+		return base.INVALID_PARAM.SetMsg(T("tag.unknown"))
+	}
+	fn(tag)
+	r.store.save()
+	return base.SUCCESS
+}
+
+func (r *HNReminder) checkTagsLocal() base.Result {
+	if r.config.RouterUrl != "" {
+		return base.ACTION_ILLEGAL.SetMsg(T("tag.by_router"))
+	}
+	return base.SUCCESS
+}
+
+// checkTagProof 按标签登记表校验扫描的标签，经路由转发的扫描也按同步来的签名哈希再校验一次，调用方需持有锁
+func (r *HNReminder) checkTagProof(proof UnlockProof, now time.Time) base.Result {
+	if proof.Source != ProofTag {
+		return base.SUCCESS
+	}
+	if proof.Value == "" {
+		return base.INVALID_PARAM.SetMsg(T("tag.missing"))
+	}
+
+	res := r.verifyTag(proof.Value, proof.Sig, now)
+	if !res.IsOk() {
+		if proof.Routed && r.config.RouterUrl != "" && r.store.data.Tags[proof.Value] == nil {
+			// 可能是刚在路由上登记、还未同步的标签，立即同步一次，再次扫描即可
+			go r.syncTags()
+		}
+		return res
+	}
+	r.store.save()
+	return base.SUCCESS
+}
+
+// verifyTag 校验标签已登记、未作废且签名正确，并记录扫描时间；未登记任何标签时所有标签都被拒绝，调用方需持有锁
+func (r *HNReminder) verifyTag(tagId, sig string, now time.Time) base.Result {
+	tag, ok := r.store.data.Tags[tagId]
+	if !ok {
+		return base.ACTION_ILLEGAL.SetMsg(T("tag.unknown"))
+	}
+	if tag.Revoked {
+		return base.ACTION_ILLEGAL.SetMsg(T("tag.revoked"))
+	}
+	if sig == "" || !hmac.Equal([]byte(tagSigHash(r.config.ClientId, tagId, sig)), []byte(tag.SigHash)) {
+		return base.ACTION_ILLEGAL.SetMsg(T("tag.bad_sig"))
+	}
+
+	tag.LastScanAt = now
+	return base.SUCCESS
+}
+
+// tagSyncLoop 定期从消息路由同步标签登记表，路由不可用时继续使用本地副本
+func (r *HNReminder) tagSyncLoop() {
+	if r.config.RouterUrl == "" {
+		return
+	}
+
+	for r.running {
+		if res := r.syncTags(); !res.IsOk() {
+			logger.Warnw("sync tags from router failed", res)
+		}
+		time.Sleep(tagSyncInterval)
+	}
+}
+
+func (r *HNReminder) syncTags() base.Result {
+	api, res := routerApiUrl(r.config.RouterUrl, "/tags")
+	if !res.IsOk() {
+		return res
+	}
+	api += "?" + url.Values{"clientId": {r.config.ClientId}}.Encode()
+
+	// 只同步ID、位置、作废状态和签名哈希，签名哈希用于校验经路由转发的扫描
+	var list []*TagInfo
+	if res = callRouter(r.routerTls, http.MethodGet, api, r.routerHeader(), &list); !res.IsOk() {
+		return res
+	}
+
+	tags := map[string]*TagInfo{}
+	for _, tag := range list {
+		tag.Secret = ""
+		tags[tag.Id] = tag
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.store.data.Tags = tags
+	r.store.save()
+	logger.Infow("synced tags from router", "count", len(tags))
+	return base.SUCCESS
+}
+
+// routerApiUrl 由消息路由的websocket地址(ws://host:port/sub_msg)得到其http接口地址
+func routerApiUrl(routerUrl, path string) (string, base.Result) {
+	u, err := url.Parse(routerUrl)
+	if err != nil {
+		return "", base.INVALID_PARAM.AppendErr("invalid router_url", err)
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/sub_msg") + path
+	u.RawQuery = ""
+	return u.String(), base.SUCCESS
+}
+
+// migrateTagSecrets 把旧版本保存的标签密钥转换为签名哈希，调用方需持有锁
+func (r *HNReminder) migrateTagSecrets() {
+	migrated := 0
+	for _, tag := range r.store.data.Tags {
+		if tag.Secret == "" {
+			continue
+		}
+		tag.SigHash = tagSigHash(r.config.ClientId, tag.Id, signTag(tag.Secret, r.config.ClientId, tag.Id))
+		tag.Secret = ""
+		migrated++
+	}
+	if migrated > 0 {
+		r.store.save()
+		logger.Infow("replaced stored tag secrets with signature hashes", "count", migrated)
+	}
+}
+
+// signTag 标签地址中的签名，需与tag_maker及消息路由保持一致
+func signTag(secret, clientId, tagId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(clientId + "|" + tagId))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// tagSigHash 签名的哈希，可以校验签名但不能用来生成签名，需与消息路由保持一致
+func tagSigHash(clientId, tagId, sig string) string {
+	sum := sha256.Sum256([]byte(clientId + "|" + tagId + "|" + sig))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkTagSecret 密钥由tag_maker生成并写入标签地址的签名，本机只用它计算签名哈希
+func checkTagSecret(secret string) base.Result {
+	if _, err := hex.DecodeString(secret); err != nil || len(secret) < 32 {
+		return base.INVALID_PARAM.SetMsg("secret must be at least 16 bytes in hex")
+	}
+	return base.SUCCESS
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// onReqTagsHandler GET /tags 列出标签，与消息路由的接口一致，但不返回密钥和签名
+func (r *HNReminder) onReqTagsHandler(c *gin.Context) {
+	bu.LogHttpRequest(nil)
+	bu.ReturnRsp(c, http.StatusOK, base.SUCCESS.SetData(r.ListTags()))
+}
+
+// onReqTagActionHandler POST /tags/:action?tag=xx[&location=xx]，action为create/label/revoke/rotate
+// create/rotate需在表单中提交tag_maker生成的secret，签名由tag_maker计算，不经接口返回
+func (r *HNReminder) onReqTagActionHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	if clientId := c.Query("clientId"); clientId != "" && clientId != r.config.ClientId {
+		bu.ReturnRsp(c, http.StatusBadRequest, base.INVALID_PARAM.SetMsg("unknown client: "+clientId))
+		return
+	}
+
+	var res base.Result
+	switch action := c.Param("action"); action {
+	case "create":
+		var info TagInfo
+		if info, res = r.CreateTag(c.Query("location"), c.PostForm("secret")); res.IsOk() {
+			res = res.SetData(info)
+		}
+	case "label":
+		res = r.LabelTag(c.Query("tag"), c.Query("location"))
+	case "revoke":
+		res = r.RevokeTag(c.Query("tag"))
+	case "rotate":
+		var info TagInfo
+		if info, res = r.RotateTagSecret(c.Query("tag"), c.PostForm("secret")); res.IsOk() {
+			res = res.SetData(info)
+		}
+	default:
+		res = base.INVALID_PARAM.SetMsg("unsupported action: " + action)
+	}
+
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func testTagReminder(t *testing.T, tags map[string]*TagInfo) *HNReminder {
+	gOptions = Options{DataDir: t.TempDir()}
+	t.Cleanup(func() { gOptions = Options{} })
+
+	r := &HNReminder{store: &_Store{data: _StoreData{Tags: tags}}}
+	r.config.ClientId = "bob"
+	return r
+}
+
+func TestVerifyTag(t *testing.T) {
+	secret := "00112233445566778899aabbccddeeff"
+	sig := signTag(secret, "bob", "kitchen-1a2b3c")
+	r := testTagReminder(t, map[string]*TagInfo{
+		"kitchen-1a2b3c": {Id: "kitchen-1a2b3c", SigHash: tagSigHash("bob", "kitchen-1a2b3c", sig)},
+		"stairs-4d5e6f":  {Id: "stairs-4d5e6f", Revoked: true, SigHash: tagSigHash("bob", "stairs-4d5e6f", signTag(secret, "bob", "stairs-4d5e6f"))},
+	})
+
+	cases := []struct {
+		name string
+		tag  string
+		sig  string
+		ok   bool
+	}{
+		{"valid", "kitchen-1a2b3c", sig, true},
+		{"wrong sig", "kitchen-1a2b3c", "0000000000000000", false},
+		{"sig hash is not a sig", "kitchen-1a2b3c", r.store.data.Tags["kitchen-1a2b3c"].SigHash, false},
+		{"unknown", "desk-000000", sig, false},
+		{"revoked", "stairs-4d5e6f", signTag(secret, "bob", "stairs-4d5e6f"), false},
+	}
+	now := time.Now()
+	for _, c := range cases {
+		if res := r.verifyTag(c.tag, c.sig, now); res.IsOk() != c.ok {
+			t.Errorf("%s: verifyTag ok = %v, want %v (%s)", c.name, res.IsOk(), c.ok, res.Message())
+		}
+	}
+	if !r.store.data.Tags["kitchen-1a2b3c"].LastScanAt.Equal(now) {
+		t.Errorf("last scan time not recorded")
+	}
+}
+
+func TestCheckTagProof(t *testing.T) {
+	secret := "00112233445566778899aabbccddeeff"
+	sig := signTag(secret, "bob", "kitchen-1a2b3c")
+	registered := map[string]*TagInfo{"kitchen-1a2b3c": {Id: "kitchen-1a2b3c", SigHash: tagSigHash("bob", "kitchen-1a2b3c", sig)}}
+
+	cases := []struct {
+		name  string
+		tags  map[string]*TagInfo
+		proof UnlockProof
+		ok    bool
+	}{
+		{"valid", registered, UnlockProof{Source: ProofTag, Value: "kitchen-1a2b3c", Sig: sig}, true},
+		{"routed valid", registered, UnlockProof{Source: ProofTag, Value: "kitchen-1a2b3c", Sig: sig, Routed: true}, true},
+		{"routed without sig", registered, UnlockProof{Source: ProofTag, Value: "kitchen-1a2b3c", Routed: true}, false},
+		{"no tag", registered, UnlockProof{Source: ProofTag}, false},
+		{"no tag without registry", map[string]*TagInfo{}, UnlockProof{Source: ProofTag}, false},
+		{"any tag without registry", map[string]*TagInfo{}, UnlockProof{Source: ProofTag, Value: "kitchen-1a2b3c", Sig: sig}, false},
+		{"not a tag", map[string]*TagInfo{}, UnlockProof{Source: ProofCode, Value: "x"}, true},
+	}
+	for _, c := range cases {
+		r := testTagReminder(t, c.tags)
+		if res := r.checkTagProof(c.proof, time.Now()); res.IsOk() != c.ok {
+			t.Errorf("%s: checkTagProof ok = %v, want %v (%s)", c.name, res.IsOk(), c.ok, res.Message())
+		}
+	}
+}
+
+func TestMigrateTagSecrets(t *testing.T) {
+	secret := "00112233445566778899aabbccddeeff"
+	r := testTagReminder(t, map[string]*TagInfo{"kitchen-1a2b3c": {Id: "kitchen-1a2b3c", Secret: secret}})
+
+	r.migrateTagSecrets()
+	tag := r.store.data.Tags["kitchen-1a2b3c"]
+	if tag.Secret != "" {
+		t.Errorf("secret still stored after migration")
+	}
+	if res := r.verifyTag(tag.Id, signTag(secret, "bob", tag.Id), time.Now()); !res.IsOk() {
+		t.Errorf("tag written before migration rejected: %s", res.Message())
+	}
+}
+
+func TestCreateTagKeepsNoSecret(t *testing.T) {
+	secret := "00112233445566778899aabbccddeeff"
+	r := testTagReminder(t, map[string]*TagInfo{})
+
+	if _, res := r.CreateTag("kitchen", "not hex"); res.IsOk() {
+		t.Errorf("CreateTag accepted an invalid secret")
+	}
+	info, res := r.CreateTag("kitchen", secret)
+	if !res.IsOk() {
+		t.Fatalf("CreateTag: %s", res.Message())
+	}
+	if info.SigHash != "" || info.Secret != "" {
+		t.Errorf("CreateTag returned %+v, want no secret or signature hash", info)
+	}
+	for _, tag := range r.ListTags() {
+		if tag.SigHash != "" || tag.Secret != "" {
+			t.Errorf("ListTags returned %+v, want no secret or signature hash", tag)
+		}
+	}
+	if res = r.verifyTag(info.Id, signTag(secret, "bob", info.Id), time.Now()); !res.IsOk() {
+		t.Errorf("tag signed by tag_maker rejected: %s", res.Message())
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -26,7 +26,7 @@ func RunAsTray(loadBuilding func()) {
 
 	sender := NewMessageBoxSender(AppName)
 
-	configFile := getConfigFilePath()
+	configFile := ConfigFilePath()
 	if res := reminder.Init(configFile, nil, sender); !res.IsOk() {
 		logger.Warnw("reminder init with error", res)
 		return
@@ -74,15 +74,19 @@ func RemoveAutoStart(loadBuilding func()) {
 func onReady() {
 	systray.SetIcon(getIcon())
 	systray.SetTitle(AppName)
-	systray.SetTooltip("多走动多喝水")
+	systray.SetTooltip(T("tray.tooltip"))
 
 	// 添加菜单项和处理方法
-	mQuit := systray.AddMenuItem("退出", "退出程序")
+	addSnoozeMenu()
+	addPomodoroMenu()
+	addUnlockMenu()
+
+	mQuit := systray.AddMenuItem(T("tray.quit"), T("tray.quit.tip"))
 	go func() {
 		for {
 			<-mQuit.ClickedCh
 			if true {
-				walk.MsgBox(nil, AppName, "不允许退出(除非你Kill它)", walk.MsgBoxOK)
+				walk.MsgBox(nil, AppName, T("tray.quit.denied"), walk.MsgBoxOK)
 				continue
 			}
 			break
@@ -102,25 +106,113 @@ func onReady() {
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
 		for range ticker.C {
-			shouldRemind, nextDuration := GetHNReminder().GetStatus()
-			seconds := int(nextDuration.Seconds()) % 3600 % 60
-			minutes := int(nextDuration.Minutes()) % 60
-			hours := int(nextDuration.Hours())
-			hit := ""
-			if hours > 0 {
-				hit += strconv.Itoa(hours) + "小时"
+			if offDuty, nextStart := GetHNReminder().GetOffDuty(); offDuty {
+				if nextStart.IsZero() {
+					systray.SetTooltip(T("tray.tooltip.off"))
+				} else {
+					systray.SetTooltip(T("tray.tooltip.off_until", nextStart.Format("01-02 15:04")))
+				}
+				continue
+			}
+
+			if pause := GetHNReminder().GetPauseStatus(); pause.Paused {
+				systray.SetTooltip(T("tray.tooltip.paused", pause.Until.Format("15:04")))
+				continue
 			}
-			if minutes > 0 {
-				hit += strconv.Itoa(minutes) + "分钟"
+
+			if until, event := GetHNReminder().GetDeferred(); !until.IsZero() {
+				systray.SetTooltip(T("tray.tooltip.deferred", until.Format("15:04"), event))
+				continue
 			}
-			if hours <= 0 && seconds > 0 {
-				hit += strconv.Itoa(seconds) + "秒"
+
+			if status := GetHNReminder().GetPomodoroStatus(); status.Phase != PhaseIdle {
+				systray.SetTooltip(pomodoroTooltip(status))
+				continue
 			}
 
+			shouldRemind, nextDuration := GetHNReminder().GetStatus()
+			hit := FormatDuration(nextDuration)
+
 			if shouldRemind {
-				systray.SetTooltip(fmt.Sprintf("多走动多喝水(请尽快打卡,距离下次提醒还有%v)", hit))
+				systray.SetTooltip(T("tray.tooltip.due", hit))
 			} else {
-				systray.SetTooltip(fmt.Sprintf("多走动多喝水(距离下次休息还有%v)", hit))
+				systray.SetTooltip(T("tray.tooltip.next", hit))
+			}
+		}
+	}()
+}
+
+func addSnoozeMenu() {
+	_, durations := GetHNReminder().GetSnoozeStatus()
+	mSnooze := systray.AddMenuItem(T("tray.snooze"), T("tray.snooze.tip"))
+	for _, minutes := range durations {
+		item := mSnooze.AddSubMenuItem(FormatDuration(time.Duration(minutes)*time.Minute), "")
+		go func(minutes int) {
+			for range item.ClickedCh {
+				res := GetHNReminder().Snooze("", minutes)
+				if !res.IsOk() {
+					walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK)
+				}
+			}
+		}(minutes)
+	}
+}
+
+func pomodoroTooltip(status PomodoroStatus) string {
+	left := FormatDuration(time.Duration(status.LeftSec) * time.Second)
+	switch {
+	case status.Paused:
+		return T("pomodoro.tip.paused", left)
+	case status.Phase == PhaseFocus:
+		return T("pomodoro.tip.focus", status.Completed+1, left)
+	case status.Scanned:
+		return T("pomodoro.tip.break", left)
+	default:
+		return T("pomodoro.tip.scan", left)
+	}
+}
+
+func addPomodoroMenu() {
+	mPomodoro := systray.AddMenuItem(T("tray.pomodoro"), T("tray.pomodoro.tip"))
+	actions := []struct {
+		key string
+		do  func() base.Result
+	}{
+		{"tray.pomodoro.start", GetHNReminder().StartPomodoro},
+		{"tray.pomodoro.pause", GetHNReminder().PausePomodoro},
+		{"tray.pomodoro.resume", GetHNReminder().ResumePomodoro},
+		{"tray.pomodoro.stop", GetHNReminder().StopPomodoro},
+	}
+	for _, action := range actions {
+		item := mPomodoro.AddSubMenuItem(T(action.key), "")
+		go func(do func() base.Result) {
+			for range item.ClickedCh {
+				if res := do(); !res.IsOk() {
+					walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK)
+				}
+			}
+		}(action.do)
+	}
+}
+
+func addUnlockMenu() {
+	mPhrase := systray.AddMenuItem(T("tray.unlock.phrase"), T("tray.unlock.phrase.tip"))
+	go func() {
+		for range mPhrase.ClickedCh {
+			if res := GetHNReminder().PromptUnlock(""); !res.IsOk() {
+				walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK)
+			}
+		}
+	}()
+
+	if !GetHNReminder().config.Guardian.enabled() {
+		return
+	}
+	mGuardian := systray.AddMenuItem(T("tray.guardian"), T("tray.guardian.tip"))
+	go func() {
+		for range mGuardian.ClickedCh {
+			if res := GetHNReminder().PromptGuardianCode(); !res.IsOk() {
+				walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK)
 			}
 		}
 	}()
@@ -150,6 +242,14 @@ func setAutoStart(appName string, ask bool) error {
 		return err
 	}
 
+	// 带全局选项启动时，开机启动也带上这些选项
+	if args := GlobalArgs(); len(args) > 0 {
+		exePath = syscall.EscapeArg(exePath)
+		for _, arg := range args {
+			exePath += " " + syscall.EscapeArg(arg)
+		}
+	}
+
 	key, _, err := registry.CreateKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Run`, registry.QUERY_VALUE|registry.SET_VALUE)
 	if err != nil {
 		return err
@@ -162,7 +262,7 @@ func setAutoStart(appName string, ask bool) error {
 	}
 
 	if ask {
-		result := walk.MsgBox(nil, AppName, "是否添加为开机自启？", walk.MsgBoxYesNo|walk.MsgBoxIconQuestion)
+		result := walk.MsgBox(nil, AppName, T("autostart.ask"), walk.MsgBoxYesNo|walk.MsgBoxIconQuestion)
 		if result != walk.DlgCmdYes {
 			logger.Warnw("user not allow to auto start", nil)
 			return nil
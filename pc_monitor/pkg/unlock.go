@@ -0,0 +1,589 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/livekit/protocol/logger"
+	"github.com/lxn/walk"
+	"github.com/patstar123/go-base"
+	bu "github.com/patstar123/go-base/utils"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	UnlockNFC    = "nfc"    // 扫描NFC标签(标签打开消息路由的/reset_remind地址)
+	UnlockQR     = "qr"     // 用手机扫描贴在别处的二维码(二维码内容为带code参数的/reset_remind地址)
+	UnlockPhrase = "phrase" // 在电脑上输入指定的短语
+	UnlockIdle   = "idle"   // 离开电脑(不操作键盘鼠标)一段时间
+	UnlockAck    = "ack"    // 确认提醒即视为完成
+)
+
+const (
+	ProofTag    = "tag"
+	ProofCode   = "code"
+	ProofPhrase = "phrase"
+	ProofAck    = "ack"
+)
+
+const ActionUnlock = "unlock"
+
+type _UnlockConfig struct {
+	Tags      []string `yaml:"tags"`                  // nfc: 允许的标签ID，为空时接受任意扫描
+	Rotate    bool     `yaml:"rotate"`                // nfc: 不能连续两次用同一个标签解除
+	Sequence  []string `yaml:"sequence"`              // nfc: 需要按顺序依次扫描的标签，配置后忽略tags
+	WithinSec int      `yaml:"within_sec"`            // nfc: 从扫描第一个标签起需在该时间内扫完整个序列，0表示不限
+	Codes     []string `yaml:"codes" secret:"true"`   // qr: 二维码中的校验码，至少配置一个
+	Phrases   []string `yaml:"phrases" secret:"true"` // phrase: 每次随机选取一句要求输入，为空时使用内置短语
+	IdleMin   int      `yaml:"idle_min"`              // idle: 需要离开电脑的分钟数，默认5
+}
+
+type _AwayConfig struct {
+	MinSec   int `yaml:"min_sec"`   // 扫描标签/二维码时需要已离开电脑的秒数，0表示不检查
+	GraceSec int `yaml:"grace_sec"` // 回到电脑后多少秒内扫描仍有效，默认60
+}
+
+// UnlockProof 一次解除提醒的凭证
+type UnlockProof struct {
+	Source string // 凭证来源: tag/code/phrase/ack
+	Value  string // 标签ID、二维码校验码或输入的短语
+	Sig    string // tag: 标签地址中的签名
+	Routed bool   // 经消息路由转发(路由已校验标签签名，本机仍按同步的登记表再校验)
+}
+
+// _ProgressReporter 多步任务实现该接口以报告完成进度
+type _ProgressReporter interface {
+	// Progress 返回当前进度(如1/2)，未开始时返回空
+	Progress(now time.Time) string
+}
+
+// UnlockTask 解除提醒需要完成的任务，每种提醒各有一个实例，由HNReminder的锁保护
+type UnlockTask interface {
+	// Kind 任务类型，即配置中的unlock
+	Kind() string
+	// Begin 提醒到期后首次展示时调用，返回附加在提醒内容后的任务说明
+	Begin(now time.Time) string
+	// Verify 校验一次外部提交的凭证，凭证有效但任务尚未全部完成(如多标签序列)时done为false
+	Verify(proof UnlockProof, now time.Time) (done bool, res base.Result)
+	// Poll 周期检查任务是否已自动完成
+	Poll(now time.Time) bool
+}
+
+func newUnlockTask(kind string, cfg *_UnlockConfig, state *_ReminderState, activity ActivitySource) (UnlockTask, base.Result) {
+	switch kind {
+	case "", UnlockNFC:
+		return &_TagTask{
+			tags:     cfg.Tags,
+			rotate:   cfg.Rotate,
+			sequence: cfg.Sequence,
+			within:   time.Duration(cfg.WithinSec) * time.Second,
+			state:    state,
+		}, base.SUCCESS
+	case UnlockQR:
+		if len(cfg.Codes) == 0 {
+			return nil, base.INVALID_PARAM.SetMsg("qr unlock requires codes")
+		}
+		return &_CodeTask{codes: cfg.Codes}, base.SUCCESS
+	case UnlockPhrase:
+		return &_PhraseTask{phrases: cfg.Phrases, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}, base.SUCCESS
+	case UnlockIdle:
+		idleMin := cfg.IdleMin
+		if idleMin <= 0 {
+			idleMin = 5
+		}
+		return &_IdleTask{idle: time.Duration(idleMin) * time.Minute, activity: activity}, base.SUCCESS
+	case UnlockAck:
+		return &_AckTask{}, base.SUCCESS
+	default:
+		return nil, base.INVALID_PARAM.SetMsg("invalid unlock: " + kind)
+	}
+}
+
+// _TagTask 扫描NFC标签，支持限定标签、轮换标签和按顺序扫描多个标签
+type _TagTask struct {
+	tags     []string
+	rotate   bool
+	sequence []string
+	within   time.Duration
+	state    *_ReminderState // 记录上次解除所用的标签，重启后轮换规则依然有效
+
+	scanned   int // 序列中已扫描的个数
+	startedAt time.Time
+}
+
+func (t *_TagTask) Kind() string { return UnlockNFC }
+
+func (t *_TagTask) Begin(now time.Time) string {
+	if len(t.sequence) > 0 {
+		return T("unlock.hint.sequence", strings.Join(t.sequence, " → "))
+	}
+	return T("unlock.hint.nfc")
+}
+
+func (t *_TagTask) Verify(proof UnlockProof, now time.Time) (bool, base.Result) {
+	if proof.Source != ProofTag {
+		return false, base.ACTION_ILLEGAL.SetMsg(T("unlock.mismatch"))
+	}
+	if proof.Value == "" {
+		return false, base.INVALID_PARAM.SetMsg(T("tag.missing"))
+	}
+	if len(t.sequence) > 0 {
+		return t.verifySequence(proof.Value, now)
+	}
+	if len(t.tags) > 0 && !containsString(t.tags, proof.Value) {
+		return false, base.INVALID_PARAM.SetMsg(T("unlock.bad_tag"))
+	}
+	if t.rotate && proof.Value == t.state.LastTag {
+		return false, base.INVALID_PARAM.SetMsg(T("unlock.same_tag"))
+	}
+
+	t.state.LastTag = proof.Value
+	return true, base.SUCCESS
+}
+
+func (t *_TagTask) verifySequence(tag string, now time.Time) (bool, base.Result) {
+	if t.expired(now) {
+		t.scanned = 0
+	}
+
+	if tag != t.sequence[t.scanned] {
+		// 扫描了第一个标签则重新开始，否则保留已有进度
+		if tag != t.sequence[0] {
+			return false, base.INVALID_PARAM.SetMsg(T("unlock.wrong_step", t.sequence[t.scanned]))
+		}
+		t.scanned = 0
+	}
+
+	if t.scanned == 0 {
+		t.startedAt = now
+	}
+	t.scanned++
+	if t.scanned < len(t.sequence) {
+		next := t.sequence[t.scanned]
+		if t.within > 0 {
+			left := FormatDuration(t.startedAt.Add(t.within).Sub(now))
+			return false, base.SUCCESS.SetMsg(T("unlock.progress.within", t.Progress(now), next, left))
+		}
+		return false, base.SUCCESS.SetMsg(T("unlock.progress", t.Progress(now), next))
+	}
+
+	t.scanned = 0
+	t.state.LastTag = tag
+	return true, base.SUCCESS
+}
+
+func (t *_TagTask) expired(now time.Time) bool {
+	return t.scanned > 0 && t.within > 0 && now.Sub(t.startedAt) > t.within
+}
+
+func (t *_TagTask) Progress(now time.Time) string {
+	if len(t.sequence) == 0 || t.scanned == 0 || t.expired(now) {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", t.scanned, len(t.sequence))
+}
+
+func (t *_TagTask) Poll(now time.Time) bool { return false }
+
+// _CodeTask 扫描二维码
+type _CodeTask struct {
+	codes []string
+}
+
+func (t *_CodeTask) Kind() string { return UnlockQR }
+
+func (t *_CodeTask) Begin(now time.Time) string { return T("unlock.hint.qr") }
+
+func (t *_CodeTask) Verify(proof UnlockProof, now time.Time) (bool, base.Result) {
+	if proof.Source != ProofCode {
+		return false, base.ACTION_ILLEGAL.SetMsg(T("unlock.mismatch"))
+	}
+	if !containsString(t.codes, proof.Value) {
+		return false, base.INVALID_PARAM.SetMsg(T("unlock.bad_code"))
+	}
+	return true, base.SUCCESS
+}
+
+func (t *_CodeTask) Poll(now time.Time) bool { return false }
+
+// _PhraseTask 输入随机选取的短语
+type _PhraseTask struct {
+	phrases []string
+	rand    *rand.Rand
+	current string
+}
+
+func (t *_PhraseTask) Kind() string { return UnlockPhrase }
+
+func (t *_PhraseTask) Begin(now time.Time) string {
+	phrases := t.phrases
+	if len(phrases) == 0 {
+		phrases = strings.Split(T("unlock.phrase.defaults"), "|")
+	}
+	t.current = phrases[t.rand.Intn(len(phrases))]
+	return T("unlock.hint.phrase", t.current)
+}
+
+func (t *_PhraseTask) Verify(proof UnlockProof, now time.Time) (bool, base.Result) {
+	if proof.Source != ProofPhrase {
+		return false, base.ACTION_ILLEGAL.SetMsg(T("unlock.mismatch"))
+	}
+	if t.current == "" || !strings.EqualFold(strings.TrimSpace(proof.Value), strings.TrimSpace(t.current)) {
+		return false, base.INVALID_PARAM.SetMsg(T("unlock.bad_phrase"))
+	}
+	t.current = ""
+	return true, base.SUCCESS
+}
+
+func (t *_PhraseTask) Poll(now time.Time) bool { return false }
+
+// _IdleTask 离开电脑一段时间
+type _IdleTask struct {
+	idle     time.Duration
+	activity ActivitySource
+}
+
+func (t *_IdleTask) Kind() string { return UnlockIdle }
+
+func (t *_IdleTask) Begin(now time.Time) string { return T("unlock.hint.idle", FormatDuration(t.idle)) }
+
+func (t *_IdleTask) Verify(proof UnlockProof, now time.Time) (bool, base.Result) {
+	return false, base.ACTION_ILLEGAL.SetMsg(T("unlock.mismatch"))
+}
+
+func (t *_IdleTask) Poll(now time.Time) bool {
+	idle, ok := t.activity.IdleDuration()
+	return ok && idle >= t.idle
+}
+
+// _AckTask 确认提醒
+type _AckTask struct{}
+
+func (t *_AckTask) Kind() string { return UnlockAck }
+
+func (t *_AckTask) Begin(now time.Time) string { return "" }
+
+func (t *_AckTask) Verify(proof UnlockProof, now time.Time) (bool, base.Result) {
+	if proof.Source != ProofAck {
+		return false, base.ACTION_ILLEGAL.SetMsg(T("unlock.mismatch"))
+	}
+	return true, base.SUCCESS
+}
+
+func (t *_AckTask) Poll(now time.Time) bool { return false }
+
+// proofFromQuery 从/reset_remind的参数中解析凭证，未带code/phrase时视为扫描标签
+func proofFromQuery(query url.Values) UnlockProof {
+	if code := query.Get("code"); code != "" {
+		return UnlockProof{Source: ProofCode, Value: code}
+	}
+	if phrase := query.Get("phrase"); phrase != "" {
+		return UnlockProof{Source: ProofPhrase, Value: phrase}
+	}
+	return UnlockProof{Source: ProofTag, Value: query.Get("tag"), Sig: query.Get("sig")}
+}
+
+// resetRemind 用凭证解除提醒，name为空时解除所有接受该凭证的提醒(含未到期的)
+func (r *HNReminder) resetRemind(name string, proof UnlockProof) base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	candidates, res := r.findReminders(name, nil)
+	if !res.IsOk() {
+		return res
+	}
+
+	now := time.Now()
+	if res := r.checkTagProof(proof, now); !res.IsOk() {
+		logger.Infow("HydrateNow: unlock rejected", "reminder", name, "tag", proof.Value, "reason", res.Message())
+		r.audit(AuditUnlockReject, proofActor(proof), name, res.Message())
+		return res
+	}
+	if res := r.checkAway(proof, now); !res.IsOk() {
+		logger.Infow("HydrateNow: unlock rejected", "reminder", name, "source", proof.Source, "reason", res.Message())
+		r.audit(AuditUnlockReject, proofActor(proof), name, res.Message())
+		return res
+	}
+
+	var done, partial []string
+	progress := ""
+	rejected := base.ACTION_ILLEGAL.SetMsg(T("unlock.no_target"))
+	for _, rem := range candidates {
+		ok, res := rem.task.Verify(proof, now)
+		if !res.IsOk() {
+			// 优先返回更具体的失败原因(如标签不对)
+			if rejected.Code() == base.ACTION_ILLEGAL.Code() {
+				rejected = res
+			}
+			continue
+		}
+
+		if !ok {
+			partial = append(partial, rem.def.Name)
+			progress = res.Message()
+			continue
+		}
+		r.completeReminder(rem, now)
+		done = append(done, rem.def.Name)
+	}
+
+	if len(done) == 0 && len(partial) == 0 {
+		logger.Infow("HydrateNow: unlock rejected", "reminder", name, "source", proof.Source, "reason", rejected.Message())
+		r.audit(AuditUnlockReject, proofActor(proof), name, rejected.Message())
+		return rejected
+	}
+
+	if len(done) == 0 {
+		logger.Infow("HydrateNow: unlock in progress", "reminders", partial, "progress", progress)
+		r.audit(AuditScan, proofActor(proof), strings.Join(partial, ","), progress)
+		return base.SUCCESS.SetMsg(progress).SetData(gin.H{"partial": partial})
+	}
+
+	logger.Infow("HydrateNow: good boy", "reminders", done, "source", proof.Source)
+	r.audit(AuditUnlock, proofActor(proof), strings.Join(done, ","), "")
+	r.store.save()
+	if r.shown != nil && !r.shown.shouldRemind {
+		r.closeReminder()
+	}
+	return base.SUCCESS.SetData(gin.H{"reminders": done, "partial": partial})
+}
+
+// checkAway 扫描标签/二维码时要求用户确实离开过电脑，防止坐在座位上扫描放在手边的标签
+func (r *HNReminder) checkAway(proof UnlockProof, now time.Time) base.Result {
+	if r.away == nil || (proof.Source != ProofTag && proof.Source != ProofCode) {
+		return base.SUCCESS
+	}
+
+	away, ok := r.away.AwayAround(now, time.Duration(r.config.Away.GraceSec)*time.Second)
+	if !ok {
+		// 以服务方式运行时无法获取输入活动，配置了away.min_sec就拒绝，不能放宽为不检查
+		logger.Warnw("activity unavailable, reject scan for away check", nil, "source", proof.Source)
+		return base.ACTION_ILLEGAL.SetMsg(T("unlock.away_unknown"))
+	}
+
+	required := time.Duration(r.config.Away.MinSec) * time.Second
+	if away < required {
+		return base.ACTION_ILLEGAL.SetMsg(T("unlock.not_away", FormatDuration(required), FormatDuration(away)))
+	}
+	return base.SUCCESS
+}
+
+// unlockReply 返回给扫描手机的文本，失败原因或部分完成的进度优先
+func unlockReply(res base.Result) string {
+	if res.Message() != "" {
+		return res.Message()
+	}
+	return "Good boy"
+}
+
+// routerReply 回复给消息路由的结果，带上结果码供路由区分解除成功与被拒绝
+func routerReply(res base.Result) []byte {
+	data, _ := json.Marshal(base.NewResult(res.Code(), unlockReply(res), nil))
+	return data
+}
+
+// ackRemind 用户确认了提醒，只对确认即可完成的提醒生效
+func (r *HNReminder) ackRemind(rem *_Reminder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ackRemindLocked(rem)
+}
+
+// ackRemindLocked 调用方需持有锁
+func (r *HNReminder) ackRemindLocked(rem *_Reminder) {
+	if !rem.shouldRemind {
+		return
+	}
+	if done, _ := rem.task.Verify(UnlockProof{Source: ProofAck}, time.Now()); !done {
+		return
+	}
+
+	logger.Infow("HydrateNow: acknowledged", "reminder", rem.def.Name)
+	r.audit(AuditUnlock, ProofAck, rem.def.Name, "")
+	r.completeReminder(rem, time.Now())
+	r.store.save()
+}
+
+// pollUnlockTasks 检查已到期提醒的任务是否已自动完成，调用方需持有锁
+func (r *HNReminder) pollUnlockTasks(now time.Time) {
+	for _, rem := range r.reminders {
+		if rem.shouldRemind && rem.task.Poll(now) {
+			logger.Infow("HydrateNow: unlock task done", "reminder", rem.def.Name, "task", rem.task.Kind())
+			r.audit(AuditUnlock, rem.task.Kind(), rem.def.Name, "")
+			r.completeReminder(rem, now)
+			r.store.save()
+			if r.shown == rem {
+				r.closeReminder()
+			}
+		}
+	}
+}
+
+// completeReminder 提醒的任务已完成，开始下个周期，调用方需持有锁
+func (r *HNReminder) completeReminder(rem *_Reminder, now time.Time) {
+	rem.shouldRemind = false
+	rem.taskHint = ""
+	rem.state.LastBreakTime = now
+	r.applySnoozePenalty(rem)
+	r.countBreak(rem, now)
+	r.onPomodoroBreakDone(rem)
+}
+
+// taskHintOf 返回本次提醒的任务说明，到期后首次调用时开始任务，调用方需持有锁
+func (r *HNReminder) taskHintOf(rem *_Reminder, now time.Time) string {
+	if rem.taskHint == "" {
+		rem.taskHint = rem.task.Begin(now)
+	}
+	return rem.taskHint
+}
+
+// PromptUnlock 弹出输入框让用户输入短语，name为空时选择第一个到期的短语提醒
+func (r *HNReminder) PromptUnlock(name string) base.Result {
+	r.mutex.Lock()
+	targets, res := r.findReminders(name, func(rem *_Reminder) bool {
+		return rem.shouldRemind && rem.task.Kind() == UnlockPhrase
+	})
+	if !res.IsOk() {
+		r.mutex.Unlock()
+		return res
+	}
+	if len(targets) == 0 {
+		r.mutex.Unlock()
+		return base.ACTION_ILLEGAL.SetMsg(T("unlock.no_target"))
+	}
+	rem := targets[0]
+	hint := r.taskHintOf(rem, time.Now())
+	r.mutex.Unlock()
+
+	go func() {
+		text, ok := promptInput(AppName, hint)
+		if !ok {
+			return
+		}
+		if res := r.resetRemind(rem.def.Name, UnlockProof{Source: ProofPhrase, Value: text}); !res.IsOk() {
+			walk.MsgBox(nil, AppName, res.Message(), walk.MsgBoxOK|walk.MsgBoxIconWarning)
+		}
+	}()
+	return base.SUCCESS
+}
+
+// addUnlockAction 需要在电脑上完成的任务，为通知添加对应的按钮
+func (r *HNReminder) addUnlockAction(n *Notification, rem *_Reminder) {
+	if rem.task.Kind() == UnlockAck {
+		// 系统通知无法回传关闭操作，点击通知本身视为确认
+		n.Url = fmt.Sprintf("http://127.0.0.1:%s/ack?name=%s", r.config.ApiPort, url.QueryEscape(rem.def.Name))
+		return
+	}
+	if rem.task.Kind() != UnlockPhrase {
+		return
+	}
+
+	n.AddAction(ActionUnlock, T("unlock.action.phrase"),
+		fmt.Sprintf("http://127.0.0.1:%s/unlock_prompt?name=%s", r.config.ApiPort, url.QueryEscape(rem.def.Name)))
+}
+
+// AckRemind 确认已到期且确认即可完成的提醒，name为空时确认所有此类提醒
+func (r *HNReminder) AckRemind(name string) base.Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	targets, res := r.findReminders(name, func(rem *_Reminder) bool {
+		return rem.shouldRemind && rem.task.Kind() == UnlockAck
+	})
+	if !res.IsOk() {
+		return res
+	}
+	if len(targets) == 0 {
+		return base.ACTION_ILLEGAL.SetMsg(T("unlock.no_target"))
+	}
+	for _, rem := range targets {
+		r.ackRemindLocked(rem)
+		if r.shown == rem {
+			r.closeReminder()
+		}
+	}
+	return base.SUCCESS
+}
+
+func (r *HNReminder) onReqAckHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	res := r.AckRemind(c.Query("name"))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+func (r *HNReminder) onReqUnlockPromptHandler(c *gin.Context) {
+	bu.LogHttpRequest(c.Request.URL.Query())
+
+	res := r.PromptUnlock(c.Query("name"))
+	if !res.IsOk() {
+		bu.ReturnRsp(c, http.StatusBadRequest, res)
+		return
+	}
+	bu.ReturnRsp(c, http.StatusOK, res)
+}
+
+// promptInput 弹出模态输入框，用户取消时ok为false
+func promptInput(title, prompt string) (text string, ok bool) {
+	// walk的窗口消息循环必须在创建窗口的线程中运行
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	dlg, err := walk.NewDialog(nil)
+	if err != nil {
+		logger.Warnw("create input dialog failed", err)
+		return "", false
+	}
+	defer dlg.Dispose()
+
+	_ = dlg.SetTitle(title)
+	if err = dlg.SetLayout(walk.NewVBoxLayout()); err != nil {
+		logger.Warnw("set input dialog layout failed", err)
+		return "", false
+	}
+
+	label, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		logger.Warnw("create input label failed", err)
+		return "", false
+	}
+	_ = label.SetText(prompt)
+
+	edit, err := walk.NewLineEdit(dlg)
+	if err != nil {
+		logger.Warnw("create input edit failed", err)
+		return "", false
+	}
+
+	button, err := walk.NewPushButton(dlg)
+	if err != nil {
+		logger.Warnw("create input button failed", err)
+		return "", false
+	}
+	_ = button.SetText(T("overlay.ok"))
+	button.Clicked().Attach(func() {
+		text = edit.Text()
+		dlg.Accept()
+	})
+	_ = dlg.SetDefaultButton(button)
+
+	return text, dlg.Run() == walk.DlgCmdOK
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTagTaskVerifySequence(t *testing.T) {
+	state := &_ReminderState{}
+	task := &_TagTask{sequence: []string{"a", "b", "c"}, within: time.Minute, state: state}
+	start := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name     string
+		tag      string
+		afterSec int
+		done     bool
+		ok       bool
+		progress string
+	}{
+		{"wrong first tag", "b", 0, false, false, ""},
+		{"first tag", "a", 0, false, true, "1/3"},
+		{"skipped a step keeps progress", "c", 5, false, false, "1/3"},
+		{"first tag restarts", "a", 10, false, true, "1/3"},
+		{"second tag", "b", 20, false, true, "2/3"},
+		// 超过within_sec，进度作废
+		{"too slow", "c", 75, false, false, ""},
+		{"restart", "a", 80, false, true, "1/3"},
+		{"again", "b", 90, false, true, "2/3"},
+		{"last tag", "c", 100, true, true, ""},
+	}
+	for _, s := range steps {
+		now := start.Add(time.Duration(s.afterSec) * time.Second)
+		done, res := task.Verify(UnlockProof{Source: ProofTag, Value: s.tag}, now)
+		if done != s.done || res.IsOk() != s.ok {
+			t.Errorf("%s: Verify(%s) = %v, %v (%s); want %v, %v", s.name, s.tag, done, res.IsOk(), res.Message(), s.done, s.ok)
+		}
+		if progress := task.Progress(now); progress != s.progress {
+			t.Errorf("%s: Progress = %q, want %q", s.name, progress, s.progress)
+		}
+	}
+	if state.LastTag != "c" {
+		t.Errorf("LastTag = %q, want c", state.LastTag)
+	}
+}
+
+func TestTagTaskVerifySequenceWithoutLimit(t *testing.T) {
+	task := &_TagTask{sequence: []string{"a", "b"}, state: &_ReminderState{}}
+	start := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+
+	if done, res := task.Verify(UnlockProof{Source: ProofTag, Value: "a"}, start); done || !res.IsOk() {
+		t.Fatalf("first tag = %v, %s", done, res.Message())
+	}
+	// within_sec为0时不限时长
+	if done, res := task.Verify(UnlockProof{Source: ProofTag, Value: "b"}, start.Add(24*time.Hour)); !done || !res.IsOk() {
+		t.Errorf("second tag a day later = %v, %s; want done", done, res.Message())
+	}
+}
+
+func TestTagTaskVerify(t *testing.T) {
+	cases := []struct {
+		name    string
+		task    _TagTask
+		lastTag string
+		proof   UnlockProof
+		done    bool
+	}{
+		{"any tag", _TagTask{}, "", UnlockProof{Source: ProofTag, Value: "x"}, true},
+		{"empty tag", _TagTask{}, "", UnlockProof{Source: ProofTag}, false},
+		{"not a tag", _TagTask{}, "", UnlockProof{Source: ProofCode, Value: "x"}, false},
+		{"allowed tag", _TagTask{tags: []string{"x", "y"}}, "", UnlockProof{Source: ProofTag, Value: "y"}, true},
+		{"unknown tag", _TagTask{tags: []string{"x", "y"}}, "", UnlockProof{Source: ProofTag, Value: "z"}, false},
+		{"rotate same tag", _TagTask{rotate: true}, "x", UnlockProof{Source: ProofTag, Value: "x"}, false},
+		{"rotate other tag", _TagTask{rotate: true}, "x", UnlockProof{Source: ProofTag, Value: "y"}, true},
+	}
+	for _, c := range cases {
+		task := c.task
+		task.state = &_ReminderState{LastTag: c.lastTag}
+		done, res := task.Verify(c.proof, time.Now())
+		if done != c.done || res.IsOk() != c.done {
+			t.Errorf("%s: Verify = %v, %s; want %v", c.name, done, res.Message(), c.done)
+		}
+		if c.done && task.state.LastTag != c.proof.Value {
+			t.Errorf("%s: LastTag = %q, want %q", c.name, task.state.LastTag, c.proof.Value)
+		}
+	}
+}
+
+type fakeActivity struct {
+	idle time.Duration
+	ok   bool
+}
+
+func (f fakeActivity) IdleDuration() (time.Duration, bool) { return f.idle, f.ok }
+
+func TestCheckAway(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name     string
+		activity fakeActivity
+		proof    UnlockProof
+		ok       bool
+	}{
+		{"away long enough", fakeActivity{5 * time.Minute, true}, UnlockProof{Source: ProofTag, Value: "x"}, true},
+		{"not away", fakeActivity{10 * time.Second, true}, UnlockProof{Source: ProofCode, Value: "x"}, false},
+		{"activity unavailable", fakeActivity{}, UnlockProof{Source: ProofTag, Value: "x"}, false},
+		{"not a scan", fakeActivity{}, UnlockProof{Source: ProofPhrase, Value: "x"}, true},
+	}
+	for _, c := range cases {
+		r := &HNReminder{}
+		r.config.Away = _AwayConfig{MinSec: 120, GraceSec: 60}
+		r.away = NewAwayTracker(c.activity, 2*time.Minute)
+		if res := r.checkAway(c.proof, now); res.IsOk() != c.ok {
+			t.Errorf("%s: checkAway = %s, want ok %v", c.name, res.Message(), c.ok)
+		}
+	}
+}
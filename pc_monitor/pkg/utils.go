@@ -1,11 +1,14 @@
 package pkg
 
 import (
+	"encoding/json"
 	"github.com/go-toast/toast"
 	"github.com/livekit/protocol/logger"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
@@ -32,13 +35,35 @@ func RedirectLogToFile() {
 	logger.Infow("Logging to " + logFilePath)
 }
 
-func getConfigFilePath() string {
-	exePath, err := os.Executable()
+func loadJsonFromTemp(name string, v any) bool {
+	content, err := ioutil.ReadFile(filepath.Join(stateDir(), name))
 	if err != nil {
-		logger.Warnw("failed to get executable path", err)
-		return ConfigFileName
-	} else {
-		return filepath.Join(filepath.Dir(exePath), ConfigFileName)
+		if !os.IsNotExist(err) {
+			logger.Warnw("failed to read temp file", err, "name", name)
+		}
+		return false
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		logger.Warnw("failed to unmarshal temp file", err, "name", name)
+		return false
+	}
+
+	return true
+}
+
+func saveJsonToTemp(name string, v any) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		logger.Warnw("failed to marshal temp file", err, "name", name)
+		return
+	}
+
+	_ = os.MkdirAll(stateDir(), 0700)
+	err = ioutil.WriteFile(filepath.Join(stateDir(), name), content, 0644)
+	if err != nil {
+		logger.Warnw("failed to write temp file", err, "name", name)
 	}
 }
 
@@ -55,15 +80,18 @@ func getIconFilePath() string {
 }
 
 type MessageSender interface {
-	Show(message string) // 有可能阻塞显示
+	Show(n *Notification) // 有可能阻塞显示，结束时通过n.Result回传结果
 	Close()
 }
 
 const (
 	MB_OK              = 0x00000000
+	MB_YESNO           = 0x00000004
+	MB_ICONWARNING     = 0x00000030
 	MB_ICONINFORMATION = 0x00000040
 	MB_SYSTEMMODAL     = 0x00001000
 	WM_CLOSE           = 0x0010
+	IDNO               = 7
 )
 
 type MessageBoxSender struct {
@@ -71,7 +99,10 @@ type MessageBoxSender struct {
 	msgBox      *syscall.LazyProc
 	findWindow  *syscall.LazyProc
 	sendMessage *syscall.LazyProc
-	title       *uint16
+	title       string
+
+	mutex sync.Mutex
+	shown *uint16 // 正在显示的弹框标题，用于查找窗口
 }
 
 func NewMessageBoxSender(title string) *MessageBoxSender {
@@ -79,24 +110,63 @@ func NewMessageBoxSender(title string) *MessageBoxSender {
 	msgBox := user32.NewProc("MessageBoxW")
 	findWindow := user32.NewProc("FindWindowW")
 	sendMessage := user32.NewProc("SendMessageW")
-	titleU16, _ := syscall.UTF16PtrFromString(title)
 
 	return &MessageBoxSender{
 		user32:      user32,
 		msgBox:      msgBox,
 		findWindow:  findWindow,
 		sendMessage: sendMessage,
-		title:       titleU16,
+		title:       title,
 	}
 }
 
-func (s *MessageBoxSender) Show(message string) {
+// Show 系统弹框只有"是/否"两个按钮，"否"对应第一个动作
+func (s *MessageBoxSender) Show(n *Notification) {
+	title := n.Title
+	if title == "" {
+		title = s.title
+	}
+
+	message := n.Message
+	flags := uintptr(MB_OK | MB_SYSTEMMODAL)
+	if len(n.Actions) > 0 {
+		flags = MB_YESNO | MB_SYSTEMMODAL
+		message += "\n\n" + T("msgbox.no_hint", n.Actions[0].Label)
+	}
+	if n.Urgency == UrgencyCritical {
+		flags |= MB_ICONWARNING
+	} else {
+		flags |= MB_ICONINFORMATION
+	}
+
+	titleU16, _ := syscall.UTF16PtrFromString(title)
 	messageU16, _ := syscall.UTF16PtrFromString(message)
-	s.msgBox.Call(0, uintptr(unsafe.Pointer(messageU16)), uintptr(unsafe.Pointer(s.title)), MB_OK|MB_ICONINFORMATION|MB_SYSTEMMODAL)
+	s.mutex.Lock()
+	s.shown = titleU16
+	s.mutex.Unlock()
+
+	ret, _, _ := s.msgBox.Call(0, uintptr(unsafe.Pointer(messageU16)), uintptr(unsafe.Pointer(titleU16)), flags)
+
+	s.mutex.Lock()
+	s.shown = nil
+	s.mutex.Unlock()
+
+	if len(n.Actions) > 0 && ret == IDNO {
+		n.replyAction(n.Actions[0].Id)
+	} else {
+		n.reply(ResultDismissed, "")
+	}
 }
 
 func (s *MessageBoxSender) Close() {
-	hwnd, _, _ := s.findWindow.Call(0, uintptr(unsafe.Pointer(s.title)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.shown == nil {
+		return
+	}
+
+	hwnd, _, _ := s.findWindow.Call(0, uintptr(unsafe.Pointer(s.shown)))
 	if hwnd != 0 {
 		s.sendMessage.Call(hwnd, WM_CLOSE, 0, 0)
 	}
@@ -112,19 +182,39 @@ func NewNotificationSender(title string) *NotificationSender {
 	}
 }
 
-func (s *NotificationSender) Show(message string) {
+// Show 系统通知无法回传点击结果，点击通知或动作按钮通过打开Url(通常是本地API)生效
+func (s *NotificationSender) Show(n *Notification) {
+	title := n.Title
+	if title == "" {
+		title = s.title
+	}
+
 	notification := toast.Notification{
 		AppID:   s.title,
-		Title:   s.title,
-		Message: message,
-		Actions: []toast.Action{
-			{"protocol", "确定", ""},
-		},
+		Title:   title,
+		Message: n.Message,
+		Icon:    n.Icon,
 	}
+	if n.Urgency == UrgencyCritical {
+		notification.Duration = toast.Long
+	}
+	if n.Url != "" {
+		notification.ActivationType = "protocol"
+		notification.ActivationArguments = n.Url
+	}
+	for _, action := range n.Actions {
+		if action.Url == "" {
+			continue
+		}
+		notification.Actions = append(notification.Actions,
+			toast.Action{Type: "protocol", Label: action.Label, Arguments: action.Url})
+	}
+
 	err := notification.Push()
 	if err != nil {
 		log.Println("Error showing reminder:", err)
 	}
+	n.reply(ResultNoFeedback, "")
 }
 
 func (s *NotificationSender) Close() {
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/juju/fslock"
+	"github.com/livekit/protocol/logger"
+	"github.com/patstar123/go-base"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const (
+	watchdogCheckInterval = 10 * time.Second
+	watchdogStartupGrace  = time.Minute      // 启动后多久开始检查心跳
+	watchdogMaxMissed     = 3                // 连续多少次心跳失败视为卡死
+	watchdogMinBackoff    = time.Second      // 第一次重启前的等待
+	watchdogMaxBackoff    = 5 * time.Minute  // 重启等待的上限
+	watchdogStableAfter   = 10 * time.Minute // 运行超过该时长后重置等待
+)
+
+// AppLockPath 单实例锁文件，监测程序运行期间一直持有
+func AppLockPath() string {
+	return filepath.Join(os.TempDir(), "hydrate_now.lock")
+}
+
+// _Watchdog 守护进程，监测程序退出、被结束或卡死时重新启动它
+type _Watchdog struct {
+	exe      string
+	apiUrl   string
+	clientId string
+	backoff  time.Duration
+}
+
+// RunWatchdog 启动并守护监测程序，重启记录在审计日志中
+func RunWatchdog(loadBuilding func()) {
+	base.InitDefaultLogger()
+
+	lock := fslock.New(filepath.Join(os.TempDir(), "hydrate_now.watchdog.lock"))
+	if err := lock.TryLock(); err != nil {
+		fmt.Println("watchdog is already running")
+		return
+	}
+	defer lock.Unlock()
+
+	exe, err := os.Executable()
+	if err != nil {
+		logger.Warnw("failed to get executable path", err)
+		return
+	}
+
+	var config _Config
+	if res := loadConfig(ConfigFilePath(), &config); !res.IsOk() {
+		logger.Warnw("load config failed", res)
+		return
+	}
+	config.fillDefaults()
+	if device, _ := loadDeviceCredential(); device != nil {
+		config.ClientId = device.ClientId
+	}
+
+	w := &_Watchdog{
+		exe:      exe,
+		apiUrl:   fmt.Sprintf("http://127.0.0.1:%s/status", config.ApiPort),
+		clientId: config.ClientId,
+		backoff:  watchdogMinBackoff,
+	}
+	logger.Infow("watchdog started", "exe", exe, "api", w.apiUrl)
+	for {
+		w.superviseOnce()
+	}
+}
+
+// superviseOnce 启动一次监测程序并等待其退出或卡死，已有实例在运行时只监视
+func (w *_Watchdog) superviseOnce() {
+	if w.monitorRunning() {
+		// 由其他方式启动的实例，无法结束它，等其退出后再接管
+		time.Sleep(watchdogCheckInterval)
+		return
+	}
+
+	cmd := exec.Command(w.exe, GlobalArgs()...)
+	if err := cmd.Start(); err != nil {
+		w.restartLater("start failed: " + err.Error())
+		return
+	}
+	startedAt := time.Now()
+	logger.Infow("monitor started", "pid", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	reason := w.watch(cmd, exited, startedAt)
+	if time.Since(startedAt) > watchdogStableAfter {
+		w.backoff = watchdogMinBackoff
+	}
+	w.restartLater(fmt.Sprintf("%s after %s", reason, shortDuration(time.Since(startedAt))))
+}
+
+// watch 等待监测程序退出，心跳连续失败时结束它，返回重启原因
+func (w *_Watchdog) watch(cmd *exec.Cmd, exited chan error, startedAt time.Time) string {
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return "exited: " + err.Error()
+			}
+			return "exited"
+		case <-ticker.C:
+			if time.Since(startedAt) < watchdogStartupGrace {
+				continue
+			}
+			if w.alive() {
+				missed = 0
+				continue
+			}
+			if missed++; missed < watchdogMaxMissed {
+				continue
+			}
+
+			logger.Warnw("monitor is not responding, kill it", nil, "pid", cmd.Process.Pid)
+			if err := cmd.Process.Kill(); err != nil {
+				logger.Warnw("kill monitor failed", err)
+			}
+			select {
+			case <-exited:
+			case <-time.After(watchdogCheckInterval):
+				logger.Warnw("killed monitor did not exit", nil, "pid", cmd.Process.Pid)
+			}
+			return "not responding"
+		}
+	}
+}
+
+// restartLater 记录重启原因，按退避时间等待后返回
+func (w *_Watchdog) restartLater(reason string) {
+	logger.Warnw("monitor stopped, restart later", nil, "reason", reason, "backoff", w.backoff)
+	// 监测程序已停止，此时由守护进程追加记录，每次重新打开以接上监测程序写入的记录
+	if audit, res := OpenAuditLog(auditFilePath()); res.IsOk() {
+		audit.Append(w.clientId, AuditWatchdog, "watchdog", "", reason)
+	} else {
+		logger.Warnw("open audit log failed, restart is not recorded", res)
+	}
+
+	time.Sleep(w.backoff)
+	w.backoff *= 2
+	if w.backoff > watchdogMaxBackoff {
+		w.backoff = watchdogMaxBackoff
+	}
+}
+
+// monitorRunning 单实例锁被占用说明已有监测程序在运行
+func (w *_Watchdog) monitorRunning() bool {
+	lock := fslock.New(AppLockPath())
+	if err := lock.TryLock(); err != nil {
+		return true
+	}
+	_ = lock.Unlock()
+	return false
+}
+
+// alive 通过本地接口检查监测程序是否还在响应
+func (w *_Watchdog) alive() bool {
+	client := &http.Client{Timeout: 5 * time.Second}
+	rsp, err := client.Get(w.apiUrl)
+	if err != nil {
+		return false
+	}
+	rsp.Body.Close()
+	return rsp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/patstar123/go-base"
+	"github.com/skip2/go-qrcode"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const manifestFileName = "tags.json"
+
+// _Tag 一个标签的信息，secret需要同步给消息路由/客户端用于校验sig
+type _Tag struct {
+	Id       string `json:"id"`
+	Location string `json:"location"`
+	Secret   string `json:"secret"`
+	Url      string `json:"url"`
+}
+
+type _Manifest struct {
+	ClientId string  `json:"clientId"`
+	Router   string  `json:"router"`
+	Tags     []*_Tag `json:"tags"`
+}
+
+type _Options struct {
+	clientId  string
+	router    string
+	locations []string
+	outDir    string
+	qrSize    int
+	tlv       bool
+	token     string
+}
+
+func main() {
+	loadBuilding()
+
+	// 第一个参数不是选项时作为子命令，默认为make
+	cmd, args := "make", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var res base.Result
+	switch cmd {
+	case "make":
+		res = runMake(args)
+	case "list":
+		res = runList(args)
+	case "create":
+		res = runCreate(args)
+	case "label":
+		res = runLabel(args)
+	case "revoke":
+		res = runRevoke(args)
+	case "rotate":
+		res = runRotate(args)
+	default:
+		res = base.INVALID_PARAM.SetMsg("unknown command: " + cmd + ", expect make/list/create/label/revoke/rotate")
+	}
+
+	if !res.IsOk() {
+		fmt.Println(res.Error())
+		os.Exit(1)
+	}
+}
+
+// runMake 离线生成标签，不登记到标签登记表
+func runMake(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("make", &opts)
+	locations := flags.String("locations", "", "comma separated locations, one tag per location, e.g. kitchen,stairwell")
+	_ = flags.Parse(args)
+	opts.locations = splitLocations(*locations)
+
+	res := makeTags(&opts)
+	if res.Code() == base.INVALID_PARAM.Code() {
+		flags.Usage()
+	}
+	return res
+}
+
+// newFlagSet 各子命令共用的选项
+func newFlagSet(name string, opts *_Options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&opts.clientId, "client", "", "client id (the registered user name)")
+	flags.StringVar(&opts.router, "router", "", "base url of msg_router (or pc_monitor in standalone mode), e.g. https://abbs.fun:28081")
+	flags.StringVar(&opts.outDir, "out", "tags", "output directory")
+	flags.IntVar(&opts.qrSize, "qr-size", 512, "size of QR code png in pixels")
+	flags.BoolVar(&opts.tlv, "tlv", false, "wrap NDEF message in TLV for writing Type 2 tag memory directly")
+	flags.StringVar(&opts.token, "token", os.Getenv("HN_TOKEN"), "guardian or admin token for the router tag API (default $HN_TOKEN)")
+	return flags
+}
+
+func splitLocations(locations string) []string {
+	var list []string
+	for _, location := range strings.Split(locations, ",") {
+		if location = strings.TrimSpace(location); location != "" {
+			list = append(list, location)
+		}
+	}
+	return list
+}
+
+func makeTags(opts *_Options) base.Result {
+	if opts.clientId == "" || opts.router == "" || len(opts.locations) == 0 {
+		return base.INVALID_PARAM.SetMsg("client, router and locations are required")
+	}
+	if _, err := url.ParseRequestURI(opts.router); err != nil {
+		return base.INVALID_PARAM.AppendErr("invalid router url", err)
+	}
+
+	manifestFile := filepath.Join(opts.outDir, manifestFileName)
+	manifest, res := openManifest(opts)
+	if !res.IsOk() {
+		return res
+	}
+
+	for _, location := range opts.locations {
+		tag, res := newTag(opts, location)
+		if !res.IsOk() {
+			return res
+		}
+
+		if res = writeTagFiles(opts, tag); !res.IsOk() {
+			return res
+		}
+		manifest.Tags = append(manifest.Tags, tag)
+		fmt.Printf("%s (%s): %s\n", tag.Id, tag.Location, tag.Url)
+	}
+
+	return saveManifest(manifestFile, manifest)
+}
+
+// openManifest 读取输出目录中的清单，输出目录只能属于一个客户端
+func openManifest(opts *_Options) (*_Manifest, base.Result) {
+	if err := os.MkdirAll(opts.outDir, 0755); err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("create output directory failed", err)
+	}
+
+	manifest, res := loadManifest(filepath.Join(opts.outDir, manifestFileName))
+	if !res.IsOk() {
+		return nil, res
+	}
+	if manifest.ClientId != "" && manifest.ClientId != opts.clientId {
+		return nil, base.INVALID_PARAM.SetMsg("output directory belongs to client " + manifest.ClientId)
+	}
+	manifest.ClientId = opts.clientId
+	manifest.Router = opts.router
+	return manifest, base.SUCCESS
+}
+
+func newTag(opts *_Options, location string) (*_Tag, base.Result) {
+	suffix, err := randomHex(3)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("generate tag id failed", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("generate secret failed", err)
+	}
+
+	tag := &_Tag{
+		Id:       location + "-" + suffix,
+		Location: location,
+		Secret:   secret,
+	}
+
+	tag.Url = tagUrl(opts, tag.Id, signTag(secret, opts.clientId, tag.Id))
+	return tag, base.SUCCESS
+}
+
+// tagUrl 写入标签的地址，扫描后由消息路由(或独立模式下的客户端)校验sig
+func tagUrl(opts *_Options, tagId, sig string) string {
+	query := url.Values{}
+	query.Set("clientId", opts.clientId)
+	query.Set("tag", tagId)
+	query.Set("sig", sig)
+	return strings.TrimRight(opts.router, "/") + "/reset_remind?" + query.Encode()
+}
+
+// signTag 标签地址中的签名，防止他人仿造标签ID
+func signTag(secret, clientId, tagId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(clientId + "|" + tagId))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// writeTagFiles 输出NDEF二进制/十六进制文件，以及打印用的二维码
+func writeTagFiles(opts *_Options, tag *_Tag) base.Result {
+	data := encodeUriRecord(tag.Url)
+	if opts.tlv {
+		data = wrapTlv(data)
+	}
+
+	prefix := filepath.Join(opts.outDir, tag.Id)
+	if err := os.WriteFile(prefix+".ndef", data, 0644); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write ndef file failed", err)
+	}
+	if err := os.WriteFile(prefix+".ndef.hex", []byte(strings.ToUpper(hex.EncodeToString(data))+"\n"), 0644); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write ndef hex file failed", err)
+	}
+	if err := qrcode.WriteFile(tag.Url, qrcode.Medium, opts.qrSize, prefix+".png"); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write qr code failed", err)
+	}
+	return base.SUCCESS
+}
+
+func loadManifest(file string) (*_Manifest, base.Result) {
+	manifest := &_Manifest{}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return manifest, base.SUCCESS
+	} else if err != nil {
+		return nil, base.INTERNAL_ERROR.AppendErr("read manifest failed", err)
+	}
+
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, base.INVALID_PARAM.AppendErr("parse manifest failed", err)
+	}
+	return manifest, base.SUCCESS
+}
+
+func saveManifest(file string, manifest *_Manifest) base.Result {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("marshal manifest failed", err)
+	}
+
+	// 清单中包含签名密钥，只允许当前用户读写
+	if err = os.WriteFile(file, data, 0600); err != nil {
+		return base.INTERNAL_ERROR.AppendErr("write manifest failed", err)
+	}
+	return base.SUCCESS
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	ndefFlagMB  = 0x80 // Message Begin
+	ndefFlagME  = 0x40 // Message End
+	ndefFlagSR  = 0x10 // Short Record，payload长度用1字节表示
+	ndefTnfWell = 0x01 // NFC Forum well-known type
+
+	tlvNdef       = 0x03
+	tlvTerminator = 0xFE
+)
+
+// uriPrefixes NFC Forum URI RTD定义的前缀缩写，按最长匹配优先排列
+var uriPrefixes = []struct {
+	code   byte
+	prefix string
+}{
+	{0x01, "http://www."},
+	{0x02, "https://www."},
+	{0x03, "http://"},
+	{0x04, "https://"},
+}
+
+// encodeUriRecord 将uri编码为只包含一条URI记录的NDEF消息
+func encodeUriRecord(uri string) []byte {
+	code := byte(0x00)
+	for _, p := range uriPrefixes {
+		if strings.HasPrefix(uri, p.prefix) {
+			code = p.code
+			uri = uri[len(p.prefix):]
+			break
+		}
+	}
+	payload := append([]byte{code}, uri...)
+
+	header := byte(ndefFlagMB | ndefFlagME | ndefTnfWell)
+	record := []byte{0, 1} // header占位, type length
+	if len(payload) < 256 {
+		header |= ndefFlagSR
+		record = append(record, byte(len(payload)))
+	} else {
+		record = binary.BigEndian.AppendUint32(record, uint32(len(payload)))
+	}
+	record[0] = header
+	record = append(record, 'U')
+	return append(record, payload...)
+}
+
+// wrapTlv 按NFC Forum Type 2标签的内存格式包装NDEF消息，可直接写入标签的数据区
+func wrapTlv(message []byte) []byte {
+	tlv := []byte{tlvNdef}
+	if len(message) < 0xFF {
+		tlv = append(tlv, byte(len(message)))
+	} else {
+		tlv = append(tlv, 0xFF, byte(len(message)>>8), byte(len(message)))
+	}
+	tlv = append(tlv, message...)
+	return append(tlv, tlvTerminator)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/patstar123/go-base"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// _TagInfo 标签登记表中的一项，与消息路由/客户端接口返回的格式一致
+type _TagInfo struct {
+	Id         string    `json:"id"`
+	Location   string    `json:"location"`
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastScanAt time.Time `json:"lastScanAt"`
+}
+
+// runList 列出已登记的标签及最后扫描时间
+func runList(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("list", &opts)
+	_ = flags.Parse(args)
+
+	var tags []_TagInfo
+	if res := callApi(&opts, http.MethodGet, "/tags", nil, nil, &tags); !res.IsOk() {
+		return res
+	}
+
+	for _, tag := range tags {
+		state := "active"
+		if tag.Revoked {
+			state = "revoked"
+		}
+		lastScan := "never"
+		if !tag.LastScanAt.IsZero() {
+			lastScan = tag.LastScanAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-24s %-16s %-8s last scan: %s\n", tag.Id, tag.Location, state, lastScan)
+	}
+	return base.SUCCESS
+}
+
+// runCreate 在登记表中创建标签并生成标签文件
+func runCreate(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("create", &opts)
+	locations := flags.String("locations", "", "comma separated locations, one tag per location, e.g. kitchen,stairwell")
+	_ = flags.Parse(args)
+	opts.locations = splitLocations(*locations)
+
+	if len(opts.locations) == 0 {
+		flags.Usage()
+		return base.INVALID_PARAM.SetMsg("locations are required")
+	}
+
+	for _, location := range opts.locations {
+		secret, err := randomHex(32)
+		if err != nil {
+			return base.INTERNAL_ERROR.AppendErr("generate secret failed", err)
+		}
+
+		var info _TagInfo
+		res := callApi(&opts, http.MethodPost, "/tags/create", url.Values{"location": {location}}, url.Values{"secret": {secret}}, &info)
+		if !res.IsOk() {
+			return res
+		}
+		if res = saveRegisteredTag(&opts, &info, secret); !res.IsOk() {
+			return res
+		}
+	}
+	return base.SUCCESS
+}
+
+// runLabel 修改标签的位置名称
+func runLabel(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("label", &opts)
+	tagId := flags.String("tag", "", "tag id")
+	location := flags.String("location", "", "new location name")
+	_ = flags.Parse(args)
+
+	return callApi(&opts, http.MethodPost, "/tags/label", url.Values{"tag": {*tagId}, "location": {*location}}, nil, nil)
+}
+
+// runRevoke 作废丢失的标签
+func runRevoke(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("revoke", &opts)
+	tagId := flags.String("tag", "", "tag id")
+	_ = flags.Parse(args)
+
+	return callApi(&opts, http.MethodPost, "/tags/revoke", url.Values{"tag": {*tagId}}, nil, nil)
+}
+
+// runRotate 更换标签密钥并重新生成标签文件，需要把新文件重新写入标签
+func runRotate(args []string) base.Result {
+	opts := _Options{}
+	flags := newFlagSet("rotate", &opts)
+	tagId := flags.String("tag", "", "tag id")
+	_ = flags.Parse(args)
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("generate secret failed", err)
+	}
+
+	var info _TagInfo
+	if res := callApi(&opts, http.MethodPost, "/tags/rotate", url.Values{"tag": {*tagId}}, url.Values{"secret": {secret}}, &info); !res.IsOk() {
+		return res
+	}
+	return saveRegisteredTag(&opts, &info, secret)
+}
+
+// saveRegisteredTag 用本地生成的密钥签名并生成标签文件，更新清单中的同名标签
+// 独立模式下客户端不会经接口返回密钥和签名，因此密钥由tag_maker生成后提交登记
+func saveRegisteredTag(opts *_Options, info *_TagInfo, secret string) base.Result {
+	manifest, res := openManifest(opts)
+	if !res.IsOk() {
+		return res
+	}
+
+	tag := &_Tag{
+		Id:       info.Id,
+		Location: info.Location,
+		Secret:   secret,
+		Url:      tagUrl(opts, info.Id, signTag(secret, opts.clientId, info.Id)),
+	}
+	if res = writeTagFiles(opts, tag); !res.IsOk() {
+		return res
+	}
+
+	replaced := false
+	for i, old := range manifest.Tags {
+		if old.Id == tag.Id {
+			manifest.Tags[i] = tag
+			replaced = true
+		}
+	}
+	if !replaced {
+		manifest.Tags = append(manifest.Tags, tag)
+	}
+	fmt.Printf("%s (%s): %s\n", tag.Id, tag.Location, tag.Url)
+
+	return saveManifest(filepath.Join(opts.outDir, manifestFileName), manifest)
+}
+
+// callApi 调用消息路由(或独立模式下客户端)的标签接口，form非空时作为表单提交，data非空时解析返回的data字段
+func callApi(opts *_Options, method, path string, query, form url.Values, data any) base.Result {
+	if opts.clientId == "" || opts.router == "" {
+		return base.INVALID_PARAM.SetMsg("client and router are required")
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("clientId", opts.clientId)
+	api := strings.TrimRight(opts.router, "/") + path + "?" + query.Encode()
+
+	var reqBody io.Reader
+	if form != nil {
+		reqBody = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, api, reqBody)
+	if err != nil {
+		return base.INVALID_PARAM.AppendErr("invalid router url", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("request "+path+" failed", err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return base.INTERNAL_ERROR.AppendErr("read response failed", err)
+	}
+	var result struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return base.INTERNAL_ERROR.SetMsg(fmt.Sprintf("unexpected response (%d): %s", rsp.StatusCode, body))
+	}
+	if result.Code != base.SUCCESS.Code() {
+		return base.NewResult(result.Code, result.Message, nil)
+	}
+
+	if data != nil {
+		if err = json.Unmarshal(result.Data, data); err != nil {
+			return base.INTERNAL_ERROR.AppendErr("parse response data failed", err)
+		}
+	}
+	return base.SUCCESS
+}